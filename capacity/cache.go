@@ -0,0 +1,98 @@
+package capacity
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// ResponseCache stores raw Azure DevOps responses on disk so runs can be
+// repeated without network access. A nil cache disables caching.
+type ResponseCache struct {
+	dir string
+	// refresh bypasses cached entries and overwrites them with fresh data.
+	refresh bool
+	// compress gzips the entries. Compressed entries are named .json.gz,
+	// so entries written with the other setting are not read.
+	compress bool
+}
+
+// NewResponseCache returns a cache in dir, or nil when dir is empty. With
+// compress, the entries are stored gzipped.
+func NewResponseCache(dir string, refresh, compress bool) (*ResponseCache, error) {
+	if dir == "" {
+		return nil, nil
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &ResponseCache{dir: dir, refresh: refresh, compress: compress}, nil
+}
+
+var unsafeCacheKeyChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+func (c *ResponseCache) path(key string) string {
+	name := unsafeCacheKeyChars.ReplaceAllString(key, "_") + ".json"
+	if c.compress {
+		name += ".gz"
+	}
+	return filepath.Join(c.dir, name)
+}
+
+// Load returns the cached response for key, if any.
+func (c *ResponseCache) Load(key string) ([]byte, bool) {
+	if c == nil || c.refresh {
+		return nil, false
+	}
+	data, err := os.ReadFile(c.path(key))
+	if err != nil {
+		return nil, false
+	}
+	if c.compress {
+		data, err = gunzip(data)
+		if err != nil {
+			slog.Warn("Ignoring unreadable cache entry", "key", key, "err", err)
+			return nil, false
+		}
+	}
+	slog.Debug("Using cached response", "key", key)
+	return data, true
+}
+
+// Store saves the response for key. Failures are logged rather than
+// returned, since the cache is only an optimisation.
+func (c *ResponseCache) Store(key string, data []byte) {
+	if c == nil {
+		return
+	}
+	if c.compress {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(data); err != nil {
+			slog.Warn("Error compressing cache entry", "key", key, "err", err)
+			return
+		}
+		if err := zw.Close(); err != nil {
+			slog.Warn("Error compressing cache entry", "key", key, "err", err)
+			return
+		}
+		data = buf.Bytes()
+	}
+	if err := os.WriteFile(c.path(key), data, 0o644); err != nil {
+		slog.Warn("Error writing cache entry", "key", key, "err", err)
+	}
+}
+
+// gunzip returns the decompressed contents of a gzipped cache entry.
+func gunzip(data []byte) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return io.ReadAll(zr)
+}
@@ -0,0 +1,185 @@
+package capacity
+
+import (
+	"fmt"
+	"math"
+)
+
+// RatioStatus tells whether a sprint's completion ratio was calculated, and
+// if not, why. Only calculated ratios take part in the average.
+type RatioStatus string
+
+const (
+	// RatioCalculated means the ratio is completed points per day available.
+	RatioCalculated RatioStatus = "calculated"
+	// RatioNoCapacity means the sprint has no days available to divide by.
+	RatioNoCapacity RatioStatus = "no_capacity"
+	// RatioNotCalculated means the sprint is missing from the points file or
+	// marked not to be calculated.
+	RatioNotCalculated RatioStatus = "not_calculated"
+)
+
+func pointsCompletedDividedByTotalDaysAvailable(completed float64, days_available int) (float64, RatioStatus) {
+
+	if days_available == 0 {
+		// Avoid divide-by-zero error
+		return 0.0, RatioNoCapacity
+	} else if completed < 0 {
+		// Sprint not calculated
+		return 0.0, RatioNotCalculated
+	} else {
+		return completed / float64(days_available), RatioCalculated
+	}
+}
+
+// RoundingMode selects how a fractional forecast becomes whole points.
+type RoundingMode string
+
+const (
+	// RoundingRound rounds to the nearest point, halves away from zero. This
+	// is the default.
+	RoundingRound RoundingMode = "round"
+	// RoundingFloor rounds down, for a conservative forecast.
+	RoundingFloor RoundingMode = "floor"
+	// RoundingCeil rounds up, for an aggressive forecast.
+	RoundingCeil RoundingMode = "ceil"
+)
+
+// ValidateRoundingMode checks that the rounding mode is known. An empty mode
+// selects RoundingRound.
+func ValidateRoundingMode(mode RoundingMode) error {
+	switch mode {
+	case "", RoundingRound, RoundingFloor, RoundingCeil:
+		return nil
+	default:
+		return fmt.Errorf("unknown rounding mode %q, expected %q, %q or %q", mode, RoundingRound, RoundingFloor, RoundingCeil)
+	}
+}
+
+// apply converts the value to whole points.
+func (m RoundingMode) apply(value float64) int {
+	switch m {
+	case RoundingFloor:
+		return int(math.Floor(value))
+	case RoundingCeil:
+		return int(math.Ceil(value))
+	default:
+		return int(math.Round(value))
+	}
+}
+
+func Forecast(daysAvailable float64, pointsCompleted float64, avgCompleted float64) int {
+	return forecastRounded(daysAvailable, pointsCompleted, avgCompleted, RoundingRound)
+}
+
+// forecastRounded is Forecast with the given rounding mode. Unknown points,
+// which are negative, are forecast like no points yet. A negative average,
+// which only bad data produces, forecasts zero points rather than a negative
+// number.
+func forecastRounded(daysAvailable float64, pointsCompleted float64, avgCompleted float64, rounding RoundingMode) int {
+	if pointsCompleted <= 0.0 && daysAvailable > 0.0 {
+		return max(rounding.apply(daysAvailable*avgCompleted), 0)
+	} else {
+		return 0
+	}
+}
+
+// Calculator holds the capacity and forecast arithmetic. Its methods are pure
+// functions of their inputs, so the numbers can be checked without Azure
+// DevOps or a database.
+type Calculator struct {
+	// Forecaster computes the forecast; nil selects the linear strategy.
+	Forecaster Forecaster
+	// StdDev is the standard deviation of the completion ratios the
+	// forecast is based on. It sets the width of the forecast range.
+	StdDev float64
+	// KeepDaysOff leaves the days off in the days available, for teams
+	// whose capacity per day already accounts for them.
+	KeepDaysOff bool
+}
+
+// DaysAvailable returns the person-days available in a sprint: the capacity
+// per day times the working days in the sprint, minus the days off unless
+// KeepDaysOff is set.
+func (c Calculator) DaysAvailable(capacityPerDay float64, sprintDays float64, daysOff int) float64 {
+	if c.KeepDaysOff {
+		return capacityPerDay * sprintDays
+	}
+	return (capacityPerDay * sprintDays) - float64(daysOff)
+}
+
+// DaysOffRatio returns the share of the sprint's capacity lost to days off:
+// the days off divided by the capacity per day times the working days in the
+// sprint. ok is false when the sprint has no capacity to divide by.
+func (c Calculator) DaysOffRatio(capacityPerDay float64, sprintDays float64, daysOff int) (ratio float64, ok bool) {
+	total := capacityPerDay * sprintDays
+	if total <= 0 {
+		return 0, false
+	}
+	return float64(daysOff) / total, true
+}
+
+// CompletionRatio returns the points completed per day available, together
+// with whether it could be calculated. A negative completed value means the
+// sprint has no known completed points.
+func (c Calculator) CompletionRatio(completed float64, daysAvailable float64) (float64, RatioStatus) {
+	return pointsCompletedDividedByTotalDaysAvailable(completed, int(daysAvailable))
+}
+
+// Forecast returns the points the team is expected to complete in a sprint.
+func (c Calculator) Forecast(daysAvailable float64, pointsCompleted float64, avgCompleted float64) int {
+	if c.Forecaster == nil {
+		return Forecast(daysAvailable, pointsCompleted, avgCompleted)
+	}
+	return c.Forecaster.Forecast(daysAvailable, pointsCompleted, avgCompleted)
+}
+
+// ForecastRange returns a low and high forecast one standard deviation of the
+// completion ratio below and above the forecast. The low end is not negative.
+// Sprints that get no forecast get a range of zero width.
+func (c Calculator) ForecastRange(daysAvailable float64, pointsCompleted float64, avgCompleted float64) (int, int) {
+	forecast := c.Forecast(daysAvailable, pointsCompleted, avgCompleted)
+	if pointsCompleted > 0.0 || daysAvailable <= 0.0 {
+		return forecast, forecast
+	}
+	spread := int(math.Round(daysAvailable * c.StdDev))
+	return max(forecast-spread, 0), forecast + spread
+}
+
+// StdDev returns the sample standard deviation of the values, or 0 when there
+// are fewer than two.
+func StdDev(values []float64) float64 {
+	if len(values) < 2 {
+		return 0
+	}
+	var sum float64
+	for _, value := range values {
+		sum += value
+	}
+	mean := sum / float64(len(values))
+	var squares float64
+	for _, value := range values {
+		squares += (value - mean) * (value - mean)
+	}
+	return math.Sqrt(squares / float64(len(values)-1))
+}
+
+// MAPE returns the mean absolute percentage error of the forecast errors,
+// actual minus forecast, relative to the actual amounts. Pairs with a zero
+// actual amount have no percentage error and are left out. It also returns
+// the number of pairs the mean is taken over, 0 when none are left.
+func MAPE(actuals, forecastErrors []float64) (float64, int) {
+	var sum float64
+	var count int
+	for i, actual := range actuals {
+		if actual == 0 {
+			continue
+		}
+		sum += math.Abs(forecastErrors[i] / actual)
+		count++
+	}
+	if count == 0 {
+		return 0, 0
+	}
+	return sum / float64(count) * 100, count
+}
@@ -0,0 +1,91 @@
+package capacity
+
+import "testing"
+
+func TestForecast(t *testing.T) {
+	tests := []struct {
+		name            string
+		daysAvailable   float64
+		pointsCompleted float64
+		avgCompleted    float64
+		want            int
+	}{
+		{name: "no points yet and days available", daysAvailable: 20, pointsCompleted: 0, avgCompleted: 1.5, want: 30},
+		{name: "no points yet and no days available", daysAvailable: 0, pointsCompleted: 0, avgCompleted: 1.5, want: 0},
+		{name: "no points yet and negative days available", daysAvailable: -2, pointsCompleted: 0, avgCompleted: 1.5, want: 0},
+		{name: "points completed", daysAvailable: 20, pointsCompleted: 12, avgCompleted: 1.5, want: 0},
+		{name: "points unknown", daysAvailable: 20, pointsCompleted: -1, avgCompleted: 1.5, want: 30},
+		{name: "negative average", daysAvailable: 20, pointsCompleted: 0, avgCompleted: -0.5, want: 0},
+		// math.Round rounds halves away from zero
+		{name: "2.5 rounds up", daysAvailable: 5, pointsCompleted: 0, avgCompleted: 0.5, want: 3},
+		{name: "3.5 rounds up", daysAvailable: 7, pointsCompleted: 0, avgCompleted: 0.5, want: 4},
+		{name: "just below a half rounds down", daysAvailable: 4.9, pointsCompleted: 0, avgCompleted: 0.5, want: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Forecast(tt.daysAvailable, tt.pointsCompleted, tt.avgCompleted); got != tt.want {
+				t.Errorf("Forecast(%g, %g, %g) = %d, want %d", tt.daysAvailable, tt.pointsCompleted, tt.avgCompleted, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestForecastRoundingModes(t *testing.T) {
+	tests := []struct {
+		mode RoundingMode
+		// want are the forecasts of 2.5 and 3.5 points
+		want [2]int
+	}{
+		{mode: "", want: [2]int{3, 4}},
+		{mode: RoundingRound, want: [2]int{3, 4}},
+		{mode: RoundingFloor, want: [2]int{2, 3}},
+		{mode: RoundingCeil, want: [2]int{3, 4}},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.mode), func(t *testing.T) {
+			forecasters := map[string]Forecaster{
+				"linear":   LinearForecaster{Rounding: tt.mode},
+				"weighted": WeightedMovingAverageForecaster{Rounding: tt.mode},
+			}
+			for name, forecaster := range forecasters {
+				for i, days := range []float64{5, 7} {
+					if got := forecaster.Forecast(days, 0, 0.5); got != tt.want[i] {
+						t.Errorf("%s: forecast of %g points = %d, want %d", name, days*0.5, got, tt.want[i])
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestForecastNegativeAverage(t *testing.T) {
+	// -0.05 per day over 10 days is -0.5 points, which rounds away from
+	// zero, floors and ceils to -1, -1 and 0; all must forecast zero.
+	for _, mode := range []RoundingMode{"", RoundingRound, RoundingFloor, RoundingCeil} {
+		forecasters := map[string]Forecaster{
+			"linear":   LinearForecaster{Rounding: mode},
+			"weighted": WeightedMovingAverageForecaster{Ratios: []float64{-0.05, -0.05}, Rounding: mode},
+		}
+		for name, forecaster := range forecasters {
+			if got := forecaster.Forecast(10, 0, -0.05); got != 0 {
+				t.Errorf("%s with rounding %q: Forecast(10, 0, -0.05) = %d, want 0", name, mode, got)
+			}
+		}
+	}
+
+	calculator := Calculator{StdDev: 0.5}
+	if low, high := calculator.ForecastRange(10, 0, -1); low != 0 || high != 5 {
+		t.Errorf("ForecastRange(10, 0, -1) = %d, %d, want 0, 5", low, high)
+	}
+}
+
+func TestValidateRoundingMode(t *testing.T) {
+	for _, mode := range []RoundingMode{"", RoundingRound, RoundingFloor, RoundingCeil} {
+		if err := ValidateRoundingMode(mode); err != nil {
+			t.Errorf("ValidateRoundingMode(%q) = %v, want nil", mode, err)
+		}
+	}
+	if err := ValidateRoundingMode("truncate"); err == nil {
+		t.Error(`ValidateRoundingMode("truncate") = nil, want an error`)
+	}
+}
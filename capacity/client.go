@@ -0,0 +1,348 @@
+// Package capacity fetches iteration capacities from Azure DevOps and turns
+// them into completion ratios and forecasts. The iterationcapacity command
+// adds configuration, storage and reporting on top of it.
+package capacity
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/microsoft/azure-devops-go-api/azuredevops"
+)
+
+// CapacityData is the response of the iteration capacities endpoint: the
+// capacity of every team in the iteration and the totals over all teams.
+type CapacityData struct {
+	Teams                        []TeamData `json:"teams"`
+	TotalIterationCapacityPerDay float64    `json:"totalIterationCapacityPerDay"`
+	TotalIterationDaysOff        int        `json:"totalIterationDaysOff"`
+	// Raw is the response body the data was decoded from.
+	Raw json.RawMessage `json:"-"`
+}
+
+// TeamData is the capacity of one team in an iteration.
+type TeamData struct {
+	TeamId             string  `json:"teamId"`
+	TeamCapacityPerDay float64 `json:"teamCapacityPerDay"`
+	TeamTotalDaysOff   int     `json:"teamTotalDaysOff"`
+}
+
+// fillTotals sums the teams into the totals when the response left both
+// totals at zero, which the endpoint occasionally does.
+func (c *CapacityData) fillTotals() {
+	if c.TotalIterationCapacityPerDay != 0 || c.TotalIterationDaysOff != 0 || len(c.Teams) == 0 {
+		return
+	}
+	for _, team := range c.Teams {
+		c.TotalIterationCapacityPerDay += team.TeamCapacityPerDay
+		c.TotalIterationDaysOff += team.TeamTotalDaysOff
+	}
+	slog.Debug("Capacity totals missing, summed the teams", "teams", len(c.Teams))
+}
+
+// Supported values of the authMode argument.
+const (
+	AuthModePAT    = "pat"
+	AuthModeBearer = "bearer"
+)
+
+// CreateAuthHeader builds the Authorization header for the token: Basic
+// authentication for a personal access token, or a Bearer header for a
+// Microsoft Entra access token.
+func CreateAuthHeader(token, authMode string) string {
+	if authMode == AuthModeBearer {
+		return "Bearer " + token
+	}
+	encoded := base64.StdEncoding.EncodeToString([]byte(":" + token))
+	return "Basic " + encoded
+}
+
+// NewConnection creates the Azure DevOps connection for the auth mode.
+func NewConnection(orgURL, token, authMode string) *azuredevops.Connection {
+	connection := azuredevops.NewAnonymousConnection(orgURL)
+	connection.AuthorizationString = CreateAuthHeader(token, authMode)
+	return connection
+}
+
+// isRetryableStatus reports whether a response status is worth retrying:
+// throttling (429) and server-side failures (5xx).
+func isRetryableStatus(statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests || statusCode >= 500
+}
+
+// retryDelay returns the wait requested by a Retry-After header, given either
+// in seconds or as an HTTP date, or the fallback when the header is absent.
+func retryDelay(retryAfter string, fallback time.Duration) time.Duration {
+	if retryAfter == "" {
+		return fallback
+	}
+	if seconds, err := strconv.Atoi(retryAfter); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if when, err := http.ParseTime(retryAfter); err == nil {
+		if wait := time.Until(when); wait > 0 {
+			return wait
+		}
+		return 0
+	}
+	return fallback
+}
+
+// doWithRetry sends the request and retries retryable responses up to
+// maxRetries times with exponential backoff. Other responses, including
+// non-retryable 4xx errors, are returned to the caller immediately.
+func doWithRetry(client *http.Client, req *http.Request, maxRetries int) (*http.Response, error) {
+	ctx := req.Context()
+	backoff := time.Second
+	for attempt := 1; ; attempt++ {
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if !isRetryableStatus(resp.StatusCode) || attempt > maxRetries {
+			return resp, nil
+		}
+
+		wait := retryDelay(resp.Header.Get("Retry-After"), backoff)
+		resp.Body.Close()
+		slog.Warn("Request failed, retrying", "status", resp.StatusCode, "wait", wait, "retry", attempt, "maxRetries", maxRetries)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		backoff *= 2
+		// The body of a POST was consumed by the failed attempt
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req.Body = body
+		}
+	}
+}
+
+// isTimeout reports whether err was caused by a deadline or client timeout.
+func isTimeout(err error) bool {
+	return errors.Is(err, context.DeadlineExceeded) || os.IsTimeout(err)
+}
+
+// FetchOptions holds the settings shared by all Azure DevOps requests.
+type FetchOptions struct {
+	MaxRetries int
+	Timeout    time.Duration
+	Cache      *ResponseCache
+	APIVersion string
+	// HTTPClient sends the requests. Sharing one client, and with it the
+	// connections of its transport, across all requests saves a TCP and TLS
+	// handshake per sprint. Nil uses a client with Timeout.
+	HTTPClient *http.Client
+	// RateLimitThreshold makes capacity requests wait before returning
+	// when the X-RateLimit-Remaining of the response is below it. Zero
+	// never waits.
+	RateLimitThreshold float64
+}
+
+// client returns the shared HTTP client, or a client with the timeout when
+// none is set.
+func (o FetchOptions) client() *http.Client {
+	if o.HTTPClient != nil {
+		return o.HTTPClient
+	}
+	return &http.Client{Timeout: o.Timeout}
+}
+
+// CapacitiesURL builds the URL of the iteration capacities endpoint. The
+// segments are joined onto the base URL, so collection paths of Azure DevOps
+// Server (e.g. https://host/tfs/DefaultCollection) and trailing slashes are
+// handled, and the project name is escaped.
+func CapacitiesURL(baseURL, project, iterationID, apiVersion string) (string, error) {
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid organization URL %q: %w", baseURL, err)
+	}
+	u := base.JoinPath(project, "_apis", "work", "iterations", iterationID, "iterationcapacities")
+	u.RawQuery = url.Values{"api-version": {apiVersion}}.Encode()
+	return u.String(), nil
+}
+
+// CapacityFetchError is the error of FetchIterationCapacity when the request
+// for an iteration's capacity failed, so callers can tell a rejected token
+// from a missing iteration, a timeout or an unreadable response.
+type CapacityFetchError struct {
+	IterationID string
+	// URL is the capacities URL that was requested, or whose cached
+	// response could not be read.
+	URL string
+	// StatusCode is the status of the final response, or 0 when no response
+	// was received, for example after a timeout, or the cached response
+	// could not be read.
+	StatusCode int
+	// Err is the underlying failure.
+	Err error
+}
+
+func (e *CapacityFetchError) Error() string {
+	if e.StatusCode != 0 && e.StatusCode != http.StatusOK {
+		return fmt.Sprintf("capacity request for iteration %s failed with status %d: %v", e.IterationID, e.StatusCode, e.Err)
+	}
+	return fmt.Sprintf("capacity request for iteration %s: %v", e.IterationID, e.Err)
+}
+
+func (e *CapacityFetchError) Unwrap() error {
+	return e.Err
+}
+
+// FetchIterationCapacity fetches the capacity of one iteration from the
+// connection's BaseUrl with its AuthorizationString. Both come from the
+// connection alone, so pointing a connection from NewConnection at another
+// server, such as an httptest.Server, redirects the request there. A failed
+// request or an unreadable cached response is reported as a
+// *CapacityFetchError.
+func FetchIterationCapacity(ctx context.Context, connection *azuredevops.Connection, project, iterationID string, opts FetchOptions) (CapacityData, error) {
+	// Build URL for the capacity API
+	capacitiesAPIURL, err := CapacitiesURL(connection.BaseUrl, project, iterationID, opts.APIVersion)
+	if err != nil {
+		return CapacityData{}, err
+	}
+
+	cacheKey := "capacity-" + iterationID
+	if body, ok := opts.Cache.Load(cacheKey); ok {
+		var capacityData CapacityData
+		if err := json.Unmarshal(body, &capacityData); err != nil {
+			// Like a request without a response, a cached one has no status
+			return CapacityData{}, &CapacityFetchError{IterationID: iterationID, URL: capacitiesAPIURL,
+				Err: fmt.Errorf("decoding cached capacity: %w; entry starts with %q", err, bodySnippet(body))}
+		}
+		capacityData.Raw = body
+		capacityData.fillTotals()
+		return capacityData, nil
+	}
+
+	// Slowing down for the rate limit is not part of the request, so it
+	// waits on the caller's context rather than the request timeout
+	callerCtx := ctx
+	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+	client := opts.client()
+
+	// Create a new HTTP request with the correct headers
+	req, err := http.NewRequestWithContext(ctx, "GET", capacitiesAPIURL, nil)
+	if err != nil {
+		return CapacityData{}, err
+	}
+
+	// Add authorization header
+	req.Header.Set("Authorization", connection.AuthorizationString)
+
+	// Send the HTTP request, retrying on throttling and server errors
+	resp, err := doWithRetry(client, req, opts.MaxRetries)
+	if err != nil {
+		if isTimeout(err) {
+			err = fmt.Errorf("timed out after %s: %w", opts.Timeout, err)
+		}
+		return CapacityData{}, &CapacityFetchError{IterationID: iterationID, URL: capacitiesAPIURL, Err: err}
+	}
+	defer resp.Body.Close()
+	fetchError := func(err error) error {
+		return &CapacityFetchError{IterationID: iterationID, URL: capacitiesAPIURL, StatusCode: resp.StatusCode, Err: err}
+	}
+
+	// Only the URL and status are logged; the Authorization header, which
+	// carries the token, never is.
+	slog.Debug("Capacity request", "url", capacitiesAPIURL, "status", resp.StatusCode)
+	slowDown := rateLimitWait(resp.Header, opts.RateLimitThreshold)
+
+	// Check the response status code
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return CapacityData{}, fetchError(fmt.Errorf("unexpected response: %s", string(bodyBytes)))
+	}
+
+	// Read the response body and unmarshal it into a CapacityData struct
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return CapacityData{}, fetchError(err)
+	}
+	var capacityData CapacityData
+	err = json.Unmarshal(body, &capacityData)
+	if err != nil {
+		return CapacityData{}, fetchError(fmt.Errorf("decoding capacity: %w; response starts with %q", err, bodySnippet(body)))
+	}
+
+	capacityData.Raw = body
+	capacityData.fillTotals()
+	opts.Cache.Store(cacheKey, body)
+	sleepContext(callerCtx, slowDown)
+	return capacityData, nil
+}
+
+// ErrTokenRejected is returned by CheckToken when Azure DevOps does not
+// accept the token for reading the team's work settings.
+var ErrTokenRejected = errors.New("token lacks permission or is invalid; it needs the Work (Read) scope")
+
+// CheckToken makes one cheap request for the team's current iteration, so an
+// invalid token or one without the Work (Read) scope is reported before the
+// database is touched rather than on the first capacity request.
+func CheckToken(ctx context.Context, connection *azuredevops.Connection, project, team string, opts FetchOptions) error {
+	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+	client := opts.client()
+
+	base, err := url.Parse(connection.BaseUrl)
+	if err != nil {
+		return fmt.Errorf("invalid organization URL %q: %w", connection.BaseUrl, err)
+	}
+	u := base.JoinPath(project, teamSegment(team), "_apis", "work", "teamsettings", "iterations")
+	u.RawQuery = url.Values{"$timeframe": {"current"}, "api-version": {opts.APIVersion}}.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", connection.AuthorizationString)
+
+	resp, err := doWithRetry(client, req, opts.MaxRetries)
+	if err != nil {
+		if isTimeout(err) {
+			return fmt.Errorf("token check timed out after %s: %w", opts.Timeout, err)
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	slog.Debug("Token check", "url", u.String(), "status", resp.StatusCode)
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusNonAuthoritativeInfo:
+		// Azure DevOps answers an unknown token with 203 and a sign-in page
+		return fmt.Errorf("%w (status %d)", ErrTokenRejected, resp.StatusCode)
+	default:
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+}
+
+// maxBodySnippet is the number of bytes of a response body quoted in errors.
+const maxBodySnippet = 200
+
+// bodySnippet returns the start of a response body for an error message, so
+// an HTML page from a proxy or sign-in redirect is recognisable.
+func bodySnippet(body []byte) string {
+	if len(body) > maxBodySnippet {
+		return string(body[:maxBodySnippet]) + "..."
+	}
+	return string(body)
+}
@@ -0,0 +1,249 @@
+package capacity
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testIterationID = "4d3b9a5e-1f2c-4b8a-9e6d-7c5a3f2e1d0b"
+
+// capacityServer is a test server for the capacity endpoints that answers
+// every request with the same status and body and records the requests.
+type capacityServer struct {
+	*httptest.Server
+	requests []*http.Request
+}
+
+// newCapacityServer starts a capacity server that answers with the body and
+// fails the test when a request lacks the Authorization header wantAuth.
+func newCapacityServer(t *testing.T, wantAuth string, status int, body string) *capacityServer {
+	t.Helper()
+	server := &capacityServer{}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		server.requests = append(server.requests, r)
+		if got := r.Header.Get("Authorization"); got != wantAuth {
+			t.Errorf("Authorization header = %q, want %q", got, wantAuth)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// testFetchOptions are the options of the capacity tests: no retries, so a
+// failure status is returned at once.
+var testFetchOptions = FetchOptions{Timeout: 5 * time.Second, APIVersion: "7.1"}
+
+const capacityResponse = `{
+	"teams": [
+		{"teamId": "a", "teamCapacityPerDay": 4, "teamTotalDaysOff": 2},
+		{"teamId": "b", "teamCapacityPerDay": 2.5, "teamTotalDaysOff": 1}
+	],
+	"totalIterationCapacityPerDay": 6.5,
+	"totalIterationDaysOff": 3
+}`
+
+func TestFetchIterationCapacity(t *testing.T) {
+	tests := []struct {
+		name     string
+		authMode string
+		wantAuth string
+	}{
+		{name: "personal access token", authMode: AuthModePAT, wantAuth: "Basic " + base64.StdEncoding.EncodeToString([]byte(":secret"))},
+		{name: "bearer token", authMode: AuthModeBearer, wantAuth: "Bearer secret"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newCapacityServer(t, tt.wantAuth, http.StatusOK, capacityResponse)
+			connection := NewConnection(server.URL+"/org", "secret", tt.authMode)
+
+			data, err := FetchIterationCapacity(context.Background(), connection, "My Project", testIterationID, testFetchOptions)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if data.TotalIterationCapacityPerDay != 6.5 || data.TotalIterationDaysOff != 3 || len(data.Teams) != 2 {
+				t.Errorf("got %+v, want the totals 6.5 and 3 of two teams", data)
+			}
+
+			if len(server.requests) != 1 {
+				t.Fatalf("got %d requests, want 1", len(server.requests))
+			}
+			request := server.requests[0]
+			if want := "/org/My Project/_apis/work/iterations/" + testIterationID + "/iterationcapacities"; request.URL.Path != want {
+				t.Errorf("path = %q, want %q", request.URL.Path, want)
+			}
+			if got := request.URL.Query().Get("api-version"); got != "7.1" {
+				t.Errorf("api-version = %q, want 7.1", got)
+			}
+		})
+	}
+}
+
+func TestFetchIterationCapacityCollectionURL(t *testing.T) {
+	// Azure DevOps Server puts the collection in the path of the base URL,
+	// often with a trailing slash. The connection lower-cases the base URL,
+	// which the server does not mind.
+	for _, collection := range []string{"/tfs/DefaultCollection", "/tfs/DefaultCollection/"} {
+		t.Run(collection, func(t *testing.T) {
+			wantAuth := CreateAuthHeader("secret", AuthModePAT)
+			server := newCapacityServer(t, wantAuth, http.StatusOK, capacityResponse)
+			connection := NewConnection(server.URL+collection, "secret", AuthModePAT)
+
+			if _, err := FetchIterationCapacity(context.Background(), connection, "Project", testIterationID, testFetchOptions); err != nil {
+				t.Fatal(err)
+			}
+			if len(server.requests) != 1 {
+				t.Fatalf("got %d requests, want 1", len(server.requests))
+			}
+			path := server.requests[0].URL.Path
+			if strings.Contains(path, "//") {
+				t.Errorf("path %q contains a double slash", path)
+			}
+			if want := "/tfs/defaultcollection/Project/_apis/work/iterations/" + testIterationID + "/iterationcapacities"; path != want {
+				t.Errorf("path = %q, want %q", path, want)
+			}
+		})
+	}
+}
+
+func TestFetchIterationCapacityWithoutTotals(t *testing.T) {
+	tests := []struct {
+		name           string
+		body           string
+		wantPerDay     float64
+		wantDaysOff    int
+		wantTeamsCount int
+	}{
+		{
+			name: "totals missing",
+			body: `{"teams": [
+				{"teamId": "a", "teamCapacityPerDay": 4, "teamTotalDaysOff": 2},
+				{"teamId": "b", "teamCapacityPerDay": 2.5, "teamTotalDaysOff": 1}
+			]}`,
+			wantPerDay: 6.5, wantDaysOff: 3, wantTeamsCount: 2,
+		},
+		{
+			name: "totals zero",
+			body: `{"teams": [{"teamId": "a", "teamCapacityPerDay": 4, "teamTotalDaysOff": 2}],
+				"totalIterationCapacityPerDay": 0, "totalIterationDaysOff": 0}`,
+			wantPerDay: 4, wantDaysOff: 2, wantTeamsCount: 1,
+		},
+		{
+			name:       "totals present",
+			body:       `{"teams": [{"teamId": "a", "teamCapacityPerDay": 4, "teamTotalDaysOff": 2}], "totalIterationCapacityPerDay": 5, "totalIterationDaysOff": 0}`,
+			wantPerDay: 5, wantDaysOff: 0, wantTeamsCount: 1,
+		},
+		{
+			name: "no teams",
+			body: `{"teams": []}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wantAuth := CreateAuthHeader("secret", AuthModePAT)
+			server := newCapacityServer(t, wantAuth, http.StatusOK, tt.body)
+			connection := NewConnection(server.URL, "secret", AuthModePAT)
+
+			data, err := FetchIterationCapacity(context.Background(), connection, "Project", testIterationID, testFetchOptions)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if data.TotalIterationCapacityPerDay != tt.wantPerDay || data.TotalIterationDaysOff != tt.wantDaysOff || len(data.Teams) != tt.wantTeamsCount {
+				t.Errorf("got capacity per day %g, days off %d and %d teams; want %g, %d and %d",
+					data.TotalIterationCapacityPerDay, data.TotalIterationDaysOff, len(data.Teams), tt.wantPerDay, tt.wantDaysOff, tt.wantTeamsCount)
+			}
+		})
+	}
+}
+
+func TestFetchIterationCapacityErrorStatus(t *testing.T) {
+	for _, status := range []int{http.StatusUnauthorized, http.StatusNotFound} {
+		t.Run(http.StatusText(status), func(t *testing.T) {
+			wantAuth := CreateAuthHeader("secret", AuthModePAT)
+			server := newCapacityServer(t, wantAuth, status, `{"message": "failed"}`)
+			connection := NewConnection(server.URL, "secret", AuthModePAT)
+
+			_, err := FetchIterationCapacity(context.Background(), connection, "Project", testIterationID, testFetchOptions)
+			var fetchErr *CapacityFetchError
+			if !errors.As(err, &fetchErr) {
+				t.Fatalf("got error %v, want a *CapacityFetchError", err)
+			}
+			if fetchErr.StatusCode != status || fetchErr.IterationID != testIterationID {
+				t.Errorf("got status %d for iteration %s, want %d for %s", fetchErr.StatusCode, fetchErr.IterationID, status, testIterationID)
+			}
+			if !strings.HasPrefix(fetchErr.URL, server.URL+"/Project/_apis/work/iterations/"+testIterationID+"/iterationcapacities?") {
+				t.Errorf("URL = %q, want the capacities URL of the iteration", fetchErr.URL)
+			}
+		})
+	}
+}
+
+func TestFetchTeamID(t *testing.T) {
+	const teamID = "0f1e2d3c-4b5a-4978-8695-a4b3c2d1e0f9"
+	wantAuth := CreateAuthHeader("secret", AuthModePAT)
+	server := newCapacityServer(t, wantAuth, http.StatusOK, `{"id": "`+teamID+`", "name": "Team A"}`)
+	connection := NewConnection(server.URL, "secret", AuthModePAT)
+
+	id, err := FetchTeamID(context.Background(), connection, "Project", "Team A", testFetchOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != teamID {
+		t.Errorf("FetchTeamID = %q, want %q", id, teamID)
+	}
+	if want := "/_apis/projects/Project/teams/Team A"; len(server.requests) != 1 || server.requests[0].URL.Path != want {
+		t.Errorf("got %d requests, want one for %q", len(server.requests), want)
+	}
+
+	// A team given by its ID needs no request
+	id, err = FetchTeamID(context.Background(), connection, "Project", "{"+strings.ToUpper(teamID)+"}", testFetchOptions)
+	if err != nil || id != teamID {
+		t.Errorf("FetchTeamID of an ID = %q, %v; want %q", id, err, teamID)
+	}
+	if len(server.requests) != 1 {
+		t.Errorf("got %d requests, want no request for an ID", len(server.requests))
+	}
+}
+
+func TestCapacityDataTeam(t *testing.T) {
+	data := CapacityData{Teams: []TeamData{
+		{TeamId: "aaaa", TeamCapacityPerDay: 4, TeamTotalDaysOff: 2},
+		{TeamId: "BBBB", TeamCapacityPerDay: 2.5, TeamTotalDaysOff: 1},
+	}}
+	if team, ok := data.Team("bbbb"); !ok || team.TeamCapacityPerDay != 2.5 || team.TeamTotalDaysOff != 1 {
+		t.Errorf("Team(bbbb) = %+v, %t; want the capacity of team BBBB", team, ok)
+	}
+	for _, id := range []string{"cccc", ""} {
+		if _, ok := data.Team(id); ok {
+			t.Errorf("Team(%q) found an entry, want none", id)
+		}
+	}
+}
+
+func TestFetchIterationCapacityCorruptCache(t *testing.T) {
+	cache, err := NewResponseCache(t.TempDir(), false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.Store("capacity-"+testIterationID, []byte("<html>not json"))
+	opts := testFetchOptions
+	opts.Cache = cache
+	connection := NewConnection("https://dev.azure.com/org", "secret", AuthModePAT)
+
+	_, err = FetchIterationCapacity(context.Background(), connection, "Project", testIterationID, opts)
+	var fetchErr *CapacityFetchError
+	if !errors.As(err, &fetchErr) {
+		t.Fatalf("got error %v, want a *CapacityFetchError", err)
+	}
+	if fetchErr.StatusCode != 0 || fetchErr.IterationID != testIterationID || !strings.Contains(fetchErr.URL, testIterationID) {
+		t.Errorf("got status %d, iteration %s and URL %q; want no status and the iteration's URL", fetchErr.StatusCode, fetchErr.IterationID, fetchErr.URL)
+	}
+}
@@ -0,0 +1,77 @@
+package capacity
+
+import "fmt"
+
+const (
+	// ForecastStrategyLinear multiplies the days available by the plain
+	// average completion ratio. This is the default.
+	ForecastStrategyLinear = "linear"
+	// ForecastStrategyWeighted multiplies the days available by a weighted
+	// moving average in which recent sprints count more heavily.
+	ForecastStrategyWeighted = "weighted-moving-average"
+)
+
+// Forecaster turns the capacity of a sprint into a forecast of the points the
+// team will complete.
+type Forecaster interface {
+	Forecast(daysAvailable float64, pointsCompleted float64, avgCompleted float64) int
+}
+
+// LinearForecaster forecasts with the average completion ratio it is given.
+type LinearForecaster struct {
+	// Rounding converts the forecast to whole points; empty rounds.
+	Rounding RoundingMode
+}
+
+func (f LinearForecaster) Forecast(daysAvailable float64, pointsCompleted float64, avgCompleted float64) int {
+	return forecastRounded(daysAvailable, pointsCompleted, avgCompleted, f.Rounding)
+}
+
+// WeightedMovingAverageForecaster forecasts with a linearly weighted average of
+// the completion ratios of the calculated sprints: the oldest sprint has
+// weight 1, the next weight 2, and so on up to the most recent sprint.
+type WeightedMovingAverageForecaster struct {
+	// Ratios holds the completion ratios of the calculated sprints, oldest first.
+	Ratios []float64
+	// Rounding converts the forecast to whole points; empty rounds.
+	Rounding RoundingMode
+}
+
+func (f WeightedMovingAverageForecaster) Forecast(daysAvailable float64, pointsCompleted float64, avgCompleted float64) int {
+	return forecastRounded(daysAvailable, pointsCompleted, f.weightedAverage(avgCompleted), f.Rounding)
+}
+
+// weightedAverage returns the weighted average of the ratios, or fallback when
+// there are none.
+func (f WeightedMovingAverageForecaster) weightedAverage(fallback float64) float64 {
+	if len(f.Ratios) == 0 {
+		return fallback
+	}
+	return WeightedAverage(f.Ratios)
+}
+
+// WeightedAverage returns the average of the ratios, oldest first, weighted
+// as WeightedMovingAverageForecaster does. It is 0 without ratios.
+func WeightedAverage(ratios []float64) float64 {
+	if len(ratios) == 0 {
+		return 0
+	}
+	var sum, weights float64
+	for i, ratio := range ratios {
+		weight := float64(i + 1)
+		sum += ratio * weight
+		weights += weight
+	}
+	return sum / weights
+}
+
+// ValidateForecastStrategy checks that the strategy name is known. An empty
+// name selects the linear strategy.
+func ValidateForecastStrategy(strategy string) error {
+	switch strategy {
+	case "", ForecastStrategyLinear, ForecastStrategyWeighted:
+		return nil
+	default:
+		return fmt.Errorf("unknown forecast strategy %q, expected %q or %q", strategy, ForecastStrategyLinear, ForecastStrategyWeighted)
+	}
+}
@@ -0,0 +1,107 @@
+package capacity
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+
+	"github.com/microsoft/azure-devops-go-api/azuredevops"
+	"github.com/microsoft/azure-devops-go-api/azuredevops/work"
+)
+
+// WorkClient is the part of the Azure DevOps work client used to list
+// iterations. The production work.Client satisfies it; tests can supply a
+// fake returning canned iterations.
+type WorkClient interface {
+	GetTeamIterations(ctx context.Context, args work.GetTeamIterationsArgs) (*[]work.TeamSettingsIteration, error)
+}
+
+// guidPattern matches a GUID, optionally in braces as Visual Studio shows it.
+var guidPattern = regexp.MustCompile(`^\{?[0-9A-Fa-f]{8}-[0-9A-Fa-f]{4}-[0-9A-Fa-f]{4}-[0-9A-Fa-f]{4}-[0-9A-Fa-f]{12}\}?$`)
+
+// isGUID reports whether s is a GUID rather than a name.
+func isGUID(s string) bool {
+	return guidPattern.MatchString(s)
+}
+
+// teamSegment returns the team as it goes into a request. Azure DevOps
+// accepts a team name or a team ID in the same place; an ID is passed in
+// its plain lower-case form, without braces.
+func teamSegment(team string) string {
+	if !isGUID(team) {
+		return team
+	}
+	id := strings.ToLower(strings.Trim(team, "{}"))
+	slog.Debug("Team given by ID", "team", id)
+	return id
+}
+
+// FetchIterations returns the team's iterations. team is the name or the ID
+// of the team. A non-empty timeframe, such
+// as "current", limits them to that time frame.
+func FetchIterations(ctx context.Context, workClient WorkClient, project, team, timeframe string, opts FetchOptions) ([]work.TeamSettingsIteration, error) {
+	team = teamSegment(team)
+	cacheKey := "iterations-" + project + "-" + team
+	var timeframeFilter *string
+	if timeframe != "" {
+		cacheKey += "-" + timeframe
+		timeframeFilter = &timeframe
+	}
+	if body, ok := opts.Cache.Load(cacheKey); ok {
+		var iterations []work.TeamSettingsIteration
+		if err := json.Unmarshal(body, &iterations); err != nil {
+			return nil, fmt.Errorf("decoding cached iterations for project %q, team %q: %w", project, team, err)
+		}
+		return iterations, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+
+	// The team iterations endpoint has no $top or continuation token: Azure
+	// DevOps returns every iteration of the team in a single response, so no
+	// paging is needed here.
+	iterations, err := workClient.GetTeamIterations(ctx, work.GetTeamIterationsArgs{
+		Project:   &project,
+		Team:      &team,
+		Timeframe: timeframeFilter,
+	})
+	if err != nil {
+		if isTimeout(err) {
+			return nil, fmt.Errorf("fetching iterations for project %q, team %q timed out after %s: %w", project, team, opts.Timeout, err)
+		}
+		return nil, fmt.Errorf("fetching iterations for project %q, team %q: %w", project, team, err)
+	}
+	if iterations == nil {
+		return nil, nil
+	}
+
+	if opts.Cache != nil {
+		if body, err := json.Marshal(*iterations); err == nil {
+			opts.Cache.Store(cacheKey, body)
+		}
+	}
+	return *iterations, nil
+}
+
+// ConnectionWorkClient wraps the Azure DevOps work client and connects it on
+// first use, since connecting already calls Azure DevOps to look up the work
+// resource area and cached runs should not need the network.
+type ConnectionWorkClient struct {
+	Connection *azuredevops.Connection
+	client     work.Client
+}
+
+func (c *ConnectionWorkClient) GetTeamIterations(ctx context.Context, args work.GetTeamIterationsArgs) (*[]work.TeamSettingsIteration, error) {
+	if c.client == nil {
+		client, err := work.NewClient(ctx, c.Connection)
+		if err != nil {
+			return nil, fmt.Errorf("creating work client: %w", err)
+		}
+		c.client = client
+	}
+	return c.client.GetTeamIterations(ctx, args)
+}
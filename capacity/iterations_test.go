@@ -0,0 +1,115 @@
+package capacity
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/microsoft/azure-devops-go-api/azuredevops/work"
+)
+
+// fakeWorkClient returns canned iterations and records the requests made.
+type fakeWorkClient struct {
+	iterations []work.TeamSettingsIteration
+	requests   []work.GetTeamIterationsArgs
+}
+
+func (c *fakeWorkClient) GetTeamIterations(ctx context.Context, args work.GetTeamIterationsArgs) (*[]work.TeamSettingsIteration, error) {
+	c.requests = append(c.requests, args)
+	iterations := c.iterations
+	return &iterations, nil
+}
+
+func TestFetchIterationsSingleRequest(t *testing.T) {
+	// The team iterations endpoint does not page, so one request is made
+	// and its iterations are returned untruncated, even beyond the 100
+	// items other Azure DevOps endpoints stop at
+	client := &fakeWorkClient{}
+	for i := 1; i <= 150; i++ {
+		name := fmt.Sprintf("Sprint %d", i)
+		client.iterations = append(client.iterations, work.TeamSettingsIteration{Name: &name})
+	}
+	opts := FetchOptions{Timeout: time.Second}
+
+	iterations, err := FetchIterations(context.Background(), client, "Project", "{6F9A0C2E-0C1B-4C1D-9E8A-3B2F1A0D4C5E}", "current", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(iterations) != 150 {
+		t.Errorf("got %d iterations, want 150", len(iterations))
+	}
+	if len(client.requests) != 1 {
+		t.Fatalf("got %d requests, want 1", len(client.requests))
+	}
+	request := client.requests[0]
+	if *request.Project != "Project" {
+		t.Errorf("project = %q, want %q", *request.Project, "Project")
+	}
+	if want := "6f9a0c2e-0c1b-4c1d-9e8a-3b2f1a0d4c5e"; *request.Team != want {
+		t.Errorf("team = %q, want %q", *request.Team, want)
+	}
+	if request.Timeframe == nil || *request.Timeframe != "current" {
+		t.Errorf("timeframe = %v, want current", request.Timeframe)
+	}
+}
+
+func TestFetchIterationsUsesCache(t *testing.T) {
+	cache, err := NewResponseCache(t.TempDir(), false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := "Sprint 1"
+	client := &fakeWorkClient{iterations: []work.TeamSettingsIteration{{Name: &name}}}
+	opts := FetchOptions{Timeout: time.Second, Cache: cache}
+
+	for i := 0; i < 2; i++ {
+		iterations, err := FetchIterations(context.Background(), client, "Project", "Team", "", opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(iterations) != 1 || *iterations[0].Name != name {
+			t.Errorf("run %d: got %v, want one iteration named %q", i+1, iterations, name)
+		}
+	}
+	if len(client.requests) != 1 {
+		t.Errorf("got %d requests, want 1; the second run should use the cache", len(client.requests))
+	}
+}
+
+func TestIsGUID(t *testing.T) {
+	tests := []struct {
+		input string
+		want  bool
+	}{
+		{input: "6f9a0c2e-0c1b-4c1d-9e8a-3b2f1a0d4c5e", want: true},
+		{input: "6F9A0C2E-0C1B-4C1D-9E8A-3B2F1A0D4C5E", want: true},
+		{input: "{6f9a0c2e-0c1b-4c1d-9e8a-3b2f1a0d4c5e}", want: true},
+		{input: "My Team", want: false},
+		{input: "", want: false},
+		{input: "6f9a0c2e0c1b4c1d9e8a3b2f1a0d4c5e", want: false},
+		{input: "6f9a0c2e-0c1b-4c1d-9e8a-3b2f1a0d4c5", want: false},
+		{input: "6f9a0c2e-0c1b-4c1d-9e8a-3b2f1a0d4c5g", want: false},
+		{input: "Team 6f9a0c2e-0c1b-4c1d-9e8a-3b2f1a0d4c5e", want: false},
+	}
+	for _, tt := range tests {
+		if got := isGUID(tt.input); got != tt.want {
+			t.Errorf("isGUID(%q) = %v, want %v", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestTeamSegment(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{input: "My Team", want: "My Team"},
+		{input: "{6F9A0C2E-0C1B-4C1D-9E8A-3B2F1A0D4C5E}", want: "6f9a0c2e-0c1b-4c1d-9e8a-3b2f1a0d4c5e"},
+	}
+	for _, tt := range tests {
+		if got := teamSegment(tt.input); got != tt.want {
+			t.Errorf("teamSegment(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package capacity
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateLimitedTransport is an http.RoundTripper that spaces out requests so
+// at most a fixed number per second are sent, however many goroutines share
+// it. Waiting for a turn stops when the request's context is done.
+type RateLimitedTransport struct {
+	Base http.RoundTripper
+
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+// NewRateLimitedTransport wraps base so it sends at most perSecond requests
+// per second. When perSecond is zero or negative base is returned as is.
+func NewRateLimitedTransport(base http.RoundTripper, perSecond float64) http.RoundTripper {
+	if perSecond <= 0 {
+		return base
+	}
+	return &RateLimitedTransport{
+		Base:     base,
+		interval: time.Duration(float64(time.Second) / perSecond),
+	}
+}
+
+// reserve returns how long the caller must wait for its turn. Each call takes
+// the next free slot, so concurrent callers are queued rather than sent in a
+// burst.
+func (t *RateLimitedTransport) reserve() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	if t.next.Before(now) {
+		t.next = now
+	}
+	wait := t.next.Sub(now)
+	t.next = t.next.Add(t.interval)
+	return wait
+}
+
+// RoundTrip waits for a free slot and then sends req with the base transport.
+func (t *RateLimitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if wait := t.reserve(); wait > 0 {
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		}
+	}
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(req)
+}
+
+// Headers Azure DevOps adds to its responses once the usage of the caller
+// nears the throttling limit. The remaining budget is given in throughput
+// units, which may be fractional.
+const (
+	headerRateLimitRemaining = "X-RateLimit-Remaining"
+	headerRateLimitResource  = "X-RateLimit-Resource"
+)
+
+// rateLimitSlowDown is how long a request waits when the remaining budget is
+// below the threshold and the response gives no Retry-After.
+const rateLimitSlowDown = time.Second
+
+// rateLimitRemaining returns the remaining budget of the response and the
+// resource it applies to. ok is false when the response has no usable
+// X-RateLimit-Remaining header, which is the case while far from the limit.
+func rateLimitRemaining(header http.Header) (remaining float64, resource string, ok bool) {
+	value := header.Get(headerRateLimitRemaining)
+	if value == "" {
+		return 0, "", false
+	}
+	remaining, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, "", false
+	}
+	return remaining, header.Get(headerRateLimitResource), true
+}
+
+// rateLimitWait logs the remaining budget of the response and returns how
+// long to wait before the next request: the Retry-After of the response, or
+// rateLimitSlowDown, when the budget is below threshold, and zero otherwise.
+// A threshold of zero only logs.
+func rateLimitWait(header http.Header, threshold float64) time.Duration {
+	remaining, resource, ok := rateLimitRemaining(header)
+	if !ok {
+		return 0
+	}
+	slog.Debug("Rate limit", "remaining", remaining, "resource", resource)
+	if remaining >= threshold {
+		return 0
+	}
+	wait := retryDelay(header.Get("Retry-After"), rateLimitSlowDown)
+	slog.Info("Approaching the Azure DevOps rate limit, slowing down", "remaining", remaining, "resource", resource, "wait", wait)
+	return wait
+}
+
+// sleepContext waits for d or until ctx is done, whichever comes first.
+func sleepContext(ctx context.Context, d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,107 @@
+package capacity
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/microsoft/azure-devops-go-api/azuredevops"
+)
+
+// FetchTeamID returns the ID of the team, which is how the capacities
+// endpoint identifies the teams of an iteration. A team given by its ID is
+// returned as is, without a request. Like iterations, the ID is cached.
+func FetchTeamID(ctx context.Context, connection *azuredevops.Connection, project, team string, opts FetchOptions) (string, error) {
+	if isGUID(team) {
+		return teamSegment(team), nil
+	}
+	cacheKey := "team-" + project + "-" + team
+	var response struct {
+		ID string `json:"id"`
+	}
+	if body, ok := opts.Cache.Load(cacheKey); ok {
+		if err := json.Unmarshal(body, &response); err != nil {
+			return "", fmt.Errorf("decoding cached team %q: %w", team, err)
+		}
+		return response.ID, nil
+	}
+
+	base, err := url.Parse(connection.BaseUrl)
+	if err != nil {
+		return "", fmt.Errorf("invalid organization URL %q: %w", connection.BaseUrl, err)
+	}
+	u := base.JoinPath("_apis", "projects", project, "teams", team)
+	u.RawQuery = url.Values{"api-version": {opts.APIVersion}}.Encode()
+	if err := getJSON(ctx, connection, u.String(), &response, opts); err != nil {
+		return "", fmt.Errorf("looking up team %q: %w", team, err)
+	}
+	if response.ID == "" {
+		return "", fmt.Errorf("looking up team %q: the response has no ID", team)
+	}
+
+	if body, err := json.Marshal(response); err == nil {
+		opts.Cache.Store(cacheKey, body)
+	}
+	return response.ID, nil
+}
+
+// Team returns the capacity of the team with the ID in the iteration. ok is
+// false when the response has no entry for the team.
+func (c CapacityData) Team(teamID string) (data TeamData, ok bool) {
+	for _, team := range c.Teams {
+		if teamID != "" && strings.EqualFold(team.TeamId, teamID) {
+			return team, true
+		}
+	}
+	return TeamData{}, false
+}
+
+// TeamFieldValues are the values of the team field, usually the area path,
+// whose work items belong to the team.
+type TeamFieldValues struct {
+	Field struct {
+		ReferenceName string `json:"referenceName"`
+	} `json:"field"`
+	Values []TeamFieldValue `json:"values"`
+}
+
+// TeamFieldValue is one value of the team field, optionally with the values
+// below it.
+type TeamFieldValue struct {
+	Value           string `json:"value"`
+	IncludeChildren bool   `json:"includeChildren"`
+}
+
+// FetchTeamFieldValues returns the team field values of the team, which
+// select the work items of the team among those of an iteration that other
+// teams share. Like iterations, they are cached.
+func FetchTeamFieldValues(ctx context.Context, connection *azuredevops.Connection, project, team string, opts FetchOptions) (TeamFieldValues, error) {
+	cacheKey := "teamfieldvalues-" + project + "-" + teamSegment(team)
+	var values TeamFieldValues
+	if body, ok := opts.Cache.Load(cacheKey); ok {
+		if err := json.Unmarshal(body, &values); err != nil {
+			return TeamFieldValues{}, fmt.Errorf("decoding cached team field values of team %q: %w", team, err)
+		}
+		return values, nil
+	}
+
+	base, err := url.Parse(connection.BaseUrl)
+	if err != nil {
+		return TeamFieldValues{}, fmt.Errorf("invalid organization URL %q: %w", connection.BaseUrl, err)
+	}
+	u := base.JoinPath(project, teamSegment(team), "_apis", "work", "teamsettings", "teamfieldvalues")
+	u.RawQuery = url.Values{"api-version": {opts.APIVersion}}.Encode()
+	if err := getJSON(ctx, connection, u.String(), &values, opts); err != nil {
+		return TeamFieldValues{}, fmt.Errorf("fetching team field values of team %q: %w", team, err)
+	}
+	if len(values.Values) == 0 {
+		return TeamFieldValues{}, fmt.Errorf("team %q has no area paths", team)
+	}
+
+	if body, err := json.Marshal(values); err == nil {
+		opts.Cache.Store(cacheKey, body)
+	}
+	return values, nil
+}
@@ -0,0 +1,190 @@
+package capacity
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/microsoft/azure-devops-go-api/azuredevops"
+)
+
+// StoryPointsField is the work item field whose values are summed into the
+// completed points of an iteration.
+const StoryPointsField = "Microsoft.VSTS.Scheduling.StoryPoints"
+
+// DefaultCompletedStates are the work item states that count as completed
+// when none are configured: Closed in the Agile and CMMI processes and Done
+// in Scrum.
+var DefaultCompletedStates = []string{"Closed", "Done"}
+
+// maxWorkItemsBatch is the most work items the batch endpoint returns per
+// request.
+const maxWorkItemsBatch = 200
+
+// wiqlQuery returns the WIQL query for the work items of the iteration path
+// in one of the states that belong to the team by its team field values.
+// Single quotes are doubled, as WIQL string literals require.
+func wiqlQuery(iterationPath string, states []string, team TeamFieldValues) string {
+	quote := func(value string) string {
+		return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+	}
+	quoted := make([]string, len(states))
+	for i, state := range states {
+		quoted[i] = quote(state)
+	}
+	field := team.Field.ReferenceName
+	if field == "" {
+		field = "System.AreaPath"
+	}
+	areas := make([]string, len(team.Values))
+	for i, value := range team.Values {
+		operator := "="
+		if value.IncludeChildren {
+			operator = "UNDER"
+		}
+		areas[i] = fmt.Sprintf("[%s] %s %s", field, operator, quote(value.Value))
+	}
+	query := fmt.Sprintf("SELECT [System.Id] FROM WorkItems WHERE [System.TeamProject] = @project AND [System.IterationPath] = %s AND [System.State] IN (%s)",
+		quote(iterationPath), strings.Join(quoted, ", "))
+	if len(areas) > 0 {
+		query += " AND (" + strings.Join(areas, " OR ") + ")"
+	}
+	return query
+}
+
+// FetchCompletedPoints returns the sum of the story points of the team's work
+// items in the iteration path that are in one of the completed states, using
+// a WIQL query in the context of the team. The team's work items are those
+// matching its team field values, from FetchTeamFieldValues, so teams
+// sharing an iteration do not count each other's items. Work items without
+// story points count as zero. Like capacities, the sum is cached per team
+// and iteration.
+func FetchCompletedPoints(ctx context.Context, connection *azuredevops.Connection, project, team string, teamValues TeamFieldValues, iterationID, iterationPath string, states []string, opts FetchOptions) (float64, error) {
+	cacheKey := "points-" + teamSegment(team) + "-" + iterationID
+	var cached struct {
+		Completed float64 `json:"completed"`
+	}
+	if body, ok := opts.Cache.Load(cacheKey); ok {
+		if err := json.Unmarshal(body, &cached); err != nil {
+			return 0, fmt.Errorf("decoding cached points for iteration %s: %w", iterationID, err)
+		}
+		return cached.Completed, nil
+	}
+	if len(states) == 0 {
+		states = DefaultCompletedStates
+	}
+
+	base, err := url.Parse(connection.BaseUrl)
+	if err != nil {
+		return 0, fmt.Errorf("invalid organization URL %q: %w", connection.BaseUrl, err)
+	}
+	query := url.Values{"api-version": {opts.APIVersion}}.Encode()
+
+	wiqlURL := base.JoinPath(project, teamSegment(team), "_apis", "wit", "wiql")
+	wiqlURL.RawQuery = query
+	var wiql struct {
+		WorkItems []struct {
+			ID int `json:"id"`
+		} `json:"workItems"`
+	}
+	err = postJSON(ctx, connection, wiqlURL.String(), map[string]string{"query": wiqlQuery(iterationPath, states, teamValues)}, &wiql, opts)
+	if err != nil {
+		return 0, fmt.Errorf("querying work items of iteration %s: %w", iterationPath, err)
+	}
+
+	batchURL := base.JoinPath(project, "_apis", "wit", "workitemsbatch")
+	batchURL.RawQuery = query
+	var completed float64
+	for start := 0; start < len(wiql.WorkItems); start += maxWorkItemsBatch {
+		end := min(start+maxWorkItemsBatch, len(wiql.WorkItems))
+		ids := make([]int, 0, end-start)
+		for _, item := range wiql.WorkItems[start:end] {
+			ids = append(ids, item.ID)
+		}
+		var batch struct {
+			Value []struct {
+				Fields map[string]any `json:"fields"`
+			} `json:"value"`
+		}
+		request := map[string]any{"ids": ids, "fields": []string{StoryPointsField}}
+		if err := postJSON(ctx, connection, batchURL.String(), request, &batch, opts); err != nil {
+			return 0, fmt.Errorf("fetching work items of iteration %s: %w", iterationPath, err)
+		}
+		for _, item := range batch.Value {
+			if points, ok := item.Fields[StoryPointsField].(float64); ok {
+				completed += points
+			}
+		}
+	}
+	slog.Debug("Completed points", "iteration", iterationPath, "workItems", len(wiql.WorkItems), "completed", completed)
+
+	cached.Completed = completed
+	if body, err := json.Marshal(cached); err == nil {
+		opts.Cache.Store(cacheKey, body)
+	}
+	return completed, nil
+}
+
+// postJSON posts request as JSON to rawURL with the connection's
+// authorization and decodes the JSON response into response.
+func postJSON(ctx context.Context, connection *azuredevops.Connection, rawURL string, request, response any, opts FetchOptions) error {
+	payload, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	return sendJSON(ctx, connection, http.MethodPost, rawURL, payload, response, opts)
+}
+
+// getJSON gets rawURL with the connection's authorization and decodes the
+// JSON response into response.
+func getJSON(ctx context.Context, connection *azuredevops.Connection, rawURL string, response any, opts FetchOptions) error {
+	return sendJSON(ctx, connection, http.MethodGet, rawURL, nil, response, opts)
+}
+
+// sendJSON sends the payload, if any, to rawURL and decodes the JSON response
+// into response.
+func sendJSON(ctx context.Context, connection *azuredevops.Connection, method, rawURL string, payload []byte, response any, opts FetchOptions) error {
+	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+
+	var body io.Reader
+	if payload != nil {
+		body = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", connection.AuthorizationString)
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := doWithRetry(opts.client(), req, opts.MaxRetries)
+	if err != nil {
+		if isTimeout(err) {
+			return fmt.Errorf("request timed out after %s: %w", opts.Timeout, err)
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	slog.Debug("Request", "method", method, "url", rawURL, "status", resp.StatusCode)
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, bodySnippet(data))
+	}
+	if err := json.Unmarshal(data, response); err != nil {
+		return fmt.Errorf("decoding response: %w; response starts with %q", err, bodySnippet(data))
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package capacity
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// workItemsServer is a test server for the WIQL and work items batch
+// endpoints. The WIQL query returns the IDs of the points, and the batch
+// returns the points of the requested IDs.
+type workItemsServer struct {
+	*httptest.Server
+	// points are the story points by work item ID; nil points have none
+	points  map[int]any
+	queries []string
+	batches [][]int
+}
+
+func newWorkItemsServer(t *testing.T, status int, points map[int]any) *workItemsServer {
+	t.Helper()
+	server := &workItemsServer{points: points}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			fmt.Fprint(w, `{"message": "access denied"}`)
+			return
+		}
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/_apis/wit/wiql"):
+			var request struct{ Query string }
+			json.Unmarshal(body, &request)
+			server.queries = append(server.queries, request.Query)
+			var items []map[string]int
+			for id := range server.points {
+				items = append(items, map[string]int{"id": id})
+			}
+			json.NewEncoder(w).Encode(map[string]any{"workItems": items})
+		case strings.HasSuffix(r.URL.Path, "/_apis/wit/workitemsbatch"):
+			var request struct{ IDs []int }
+			json.Unmarshal(body, &request)
+			server.batches = append(server.batches, request.IDs)
+			var value []map[string]any
+			for _, id := range request.IDs {
+				fields := map[string]any{}
+				if points := server.points[id]; points != nil {
+					fields[StoryPointsField] = points
+				}
+				value = append(value, map[string]any{"id": id, "fields": fields})
+			}
+			json.NewEncoder(w).Encode(map[string]any{"value": value})
+		default:
+			t.Errorf("unexpected request for %s", r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// testTeamValues are the team field values of a team owning one area path
+// and its children.
+func testTeamValues() TeamFieldValues {
+	var values TeamFieldValues
+	values.Field.ReferenceName = "System.AreaPath"
+	values.Values = []TeamFieldValue{{Value: `Project\Team A`, IncludeChildren: true}}
+	return values
+}
+
+func TestFetchCompletedPoints(t *testing.T) {
+	server := newWorkItemsServer(t, http.StatusOK, map[int]any{1: 3.0, 2: 5.0, 3: nil, 4: 0.5})
+	connection := NewConnection(server.URL, "secret", AuthModePAT)
+
+	completed, err := FetchCompletedPoints(context.Background(), connection, "Project", "Team A", testTeamValues(), testIterationID, `Project\Sprint 1`, nil, testFetchOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if completed != 8.5 {
+		t.Errorf("completed = %g, want 8.5", completed)
+	}
+	if len(server.queries) != 1 || len(server.batches) != 1 || len(server.batches[0]) != 4 {
+		t.Fatalf("got %d queries and batches %v, want one query and one batch of 4", len(server.queries), server.batches)
+	}
+	for _, want := range []string{
+		`[System.IterationPath] = 'Project\Sprint 1'`,
+		`[System.State] IN ('Closed', 'Done')`,
+		`AND ([System.AreaPath] UNDER 'Project\Team A')`,
+	} {
+		if !strings.Contains(server.queries[0], want) {
+			t.Errorf("query %q does not contain %q", server.queries[0], want)
+		}
+	}
+}
+
+func TestFetchCompletedPointsWithoutWorkItems(t *testing.T) {
+	server := newWorkItemsServer(t, http.StatusOK, nil)
+	connection := NewConnection(server.URL, "secret", AuthModePAT)
+
+	completed, err := FetchCompletedPoints(context.Background(), connection, "Project", "Team A", testTeamValues(), testIterationID, `Project\Sprint 1`, nil, testFetchOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if completed != 0 {
+		t.Errorf("completed = %g, want 0", completed)
+	}
+	if len(server.batches) != 0 {
+		t.Errorf("got %d batch requests, want none without work items", len(server.batches))
+	}
+}
+
+func TestFetchCompletedPointsErrorStatus(t *testing.T) {
+	server := newWorkItemsServer(t, http.StatusForbidden, nil)
+	connection := NewConnection(server.URL, "secret", AuthModePAT)
+
+	_, err := FetchCompletedPoints(context.Background(), connection, "Project", "Team A", testTeamValues(), testIterationID, `Project\Sprint 1`, nil, testFetchOptions)
+	if err == nil || !strings.Contains(err.Error(), "unexpected status code 403") || !strings.Contains(err.Error(), "access denied") {
+		t.Errorf("got error %v, want one with the status and the response", err)
+	}
+}
+
+func TestWiqlQueryAreaPaths(t *testing.T) {
+	values := testTeamValues()
+	values.Values = append(values.Values, TeamFieldValue{Value: `Project\Team's Area`})
+
+	query := wiqlQuery("Project", []string{"Done"}, values)
+	if want := `AND ([System.AreaPath] UNDER 'Project\Team A' OR [System.AreaPath] = 'Project\Team''s Area')`; !strings.HasSuffix(query, want) {
+		t.Errorf("query %q does not end in %q", query, want)
+	}
+}
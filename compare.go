@@ -0,0 +1,139 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// sprintKey identifies the row of a team's sprint across databases.
+type sprintKey struct {
+	Team         string
+	SprintNumber int
+}
+
+// readForecasts returns the forecast of every row in the capacity table of
+// the SQLite database at path, nil where a row has none. The database is
+// opened read-only and not migrated; a table from before rows were keyed on
+// team reads every row with an empty team.
+func readForecasts(path, table string) (map[sprintKey]*int64, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite3", "file:"+(&url.URL{Path: path}).EscapedPath()+"?mode=ro")
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	columns, err := db.Query(fmt.Sprintf(`SELECT * FROM %s LIMIT 0`, quoteIdentifier(table)))
+	if err != nil {
+		return nil, err
+	}
+	names, err := columns.Columns()
+	columns.Close()
+	if err != nil {
+		return nil, err
+	}
+	team := "''"
+	for _, name := range names {
+		if strings.EqualFold(name, "team") {
+			team = "team"
+		}
+	}
+
+	rows, err := db.Query(fmt.Sprintf(`SELECT %s, sprint_number, forecasted_completed FROM %s`, team, quoteIdentifier(table)))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	forecasts := make(map[sprintKey]*int64)
+	for rows.Next() {
+		var team sql.NullString
+		var key sprintKey
+		var forecast sql.NullInt64
+		if err := rows.Scan(&team, &key.SprintNumber, &forecast); err != nil {
+			return nil, err
+		}
+		key.Team = team.String
+		forecasts[key] = nil
+		if forecast.Valid {
+			value := forecast.Int64
+			forecasts[key] = &value
+		}
+	}
+	return forecasts, rows.Err()
+}
+
+// formatForecast returns the forecast, or "none" when there is none.
+func formatForecast(forecast *int64) string {
+	if forecast == nil {
+		return "none"
+	}
+	return strconv.FormatInt(*forecast, 10)
+}
+
+// compareForecasts writes, per team and sprint, whether the forecast of the
+// rows increased, decreased or stayed the same compared to the old
+// forecasts, followed by a count of each. Sprints only in rows are reported
+// as added, and sprints only in old as removed. An old sprint without a team,
+// read from a database of a single team, matches the sprint of any team.
+func compareForecasts(w io.Writer, old map[sprintKey]*int64, rows []IterationCapacityRow) {
+	counts := make(map[string]int)
+	report := func(key sprintKey, change, detail string) {
+		counts[change]++
+		fmt.Fprintf(w, "%-10s %s sprint %d: %s\n", change, key.Team, key.SprintNumber, detail)
+	}
+
+	fmt.Fprintln(w, "========== Forecast changes ==========")
+	seen := make(map[sprintKey]bool)
+	for _, row := range rows {
+		key := sprintKey{Team: row.Team, SprintNumber: row.SprintNumber}
+		oldKey := key
+		if _, ok := old[oldKey]; !ok {
+			oldKey.Team = ""
+		}
+		seen[oldKey] = true
+		previous, ok := old[oldKey]
+		newForecast := row.ForecastedCompleted
+		switch {
+		case !ok:
+			report(key, "added", formatForecast(newForecast))
+		case previous == nil && newForecast == nil:
+			report(key, "unchanged", "none")
+		case previous == nil || newForecast == nil:
+			report(key, "changed", formatForecast(previous)+" -> "+formatForecast(newForecast))
+		case *newForecast > *previous:
+			report(key, "increased", formatForecast(previous)+" -> "+formatForecast(newForecast))
+		case *newForecast < *previous:
+			report(key, "decreased", formatForecast(previous)+" -> "+formatForecast(newForecast))
+		default:
+			report(key, "unchanged", formatForecast(newForecast))
+		}
+	}
+
+	var removed []sprintKey
+	for key := range old {
+		if !seen[key] {
+			removed = append(removed, key)
+		}
+	}
+	sort.Slice(removed, func(i, j int) bool {
+		if removed[i].Team != removed[j].Team {
+			return removed[i].Team < removed[j].Team
+		}
+		return removed[i].SprintNumber < removed[j].SprintNumber
+	})
+	for _, key := range removed {
+		report(key, "removed", formatForecast(old[key]))
+	}
+
+	fmt.Fprintf(w, "Increased: %d, decreased: %d, unchanged: %d, changed: %d, added: %d, removed: %d\n\n",
+		counts["increased"], counts["decreased"], counts["unchanged"], counts["changed"], counts["added"], counts["removed"])
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCompareWithDatabaseWithoutTeam(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "old.sqlite")
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A table from before rows were keyed on team
+	_, err = db.Exec(`CREATE TABLE iteration_capacity (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT,
+		sprint_number INTEGER,
+		forecasted_completed INTEGER
+	)`)
+	if err == nil {
+		_, err = db.Exec(`INSERT INTO iteration_capacity (name, sprint_number, forecasted_completed)
+			VALUES ('Sprint 1', 1, NULL), ('Sprint 2', 2, 10), ('Sprint 3', 3, 12)`)
+	}
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	old, err := readForecasts(path, defaultTableName)
+	if err != nil {
+		t.Fatalf("readForecasts on a table without team: %v", err)
+	}
+	if len(old) != 3 {
+		t.Fatalf("readForecasts returned %d sprints, want 3", len(old))
+	}
+
+	forecast := int64(14)
+	rows := []IterationCapacityRow{
+		{Team: "Team A", SprintNumber: 1},
+		{Team: "Team A", SprintNumber: 2, ForecastedCompleted: &forecast},
+		{Team: "Team A", SprintNumber: 4},
+	}
+	var out strings.Builder
+	compareForecasts(&out, old, rows)
+	for _, want := range []string{
+		"unchanged  Team A sprint 1: none",
+		"increased  Team A sprint 2: 10 -> 14",
+		"added      Team A sprint 4: none",
+		"removed     sprint 3: 12",
+		"Increased: 1, decreased: 0, unchanged: 1, changed: 0, added: 1, removed: 1",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("comparison does not contain %q:\n%s", want, out.String())
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/microsoft/azure-devops-go-api/azuredevops"
+	"github.com/microsoft/azure-devops-go-api/azuredevops/work"
+)
+
+// iterationDateRange returns the start and finish date of the iteration, or an
+// error when either is missing or the range is reversed.
+func iterationDateRange(iteration work.TeamSettingsIteration) (time.Time, time.Time, error) {
+	attributes := iteration.Attributes
+	if attributes == nil || attributes.StartDate == nil || attributes.FinishDate == nil {
+		return time.Time{}, time.Time{}, errors.New("iteration has no start or finish date")
+	}
+	start := attributes.StartDate.Time
+	finish := attributes.FinishDate.Time
+	if start.IsZero() || finish.IsZero() {
+		return time.Time{}, time.Time{}, errors.New("iteration has no start or finish date")
+	}
+	if finish.Before(start) {
+		return time.Time{}, time.Time{}, fmt.Errorf("iteration finishes (%s) before it starts (%s)", finish.Format(time.DateOnly), start.Format(time.DateOnly))
+	}
+	return start, finish, nil
+}
+
+// iterationDates returns the start and finish date of the iteration as
+// YYYY-MM-DD, each "" when Azure DevOps did not set it.
+func iterationDates(iteration work.TeamSettingsIteration) (start, finish string) {
+	attributes := iteration.Attributes
+	if attributes == nil {
+		return "", ""
+	}
+	if attributes.StartDate != nil && !attributes.StartDate.Time.IsZero() {
+		start = attributes.StartDate.Time.Format(time.DateOnly)
+	}
+	if attributes.FinishDate != nil && !attributes.FinishDate.Time.IsZero() {
+		finish = attributes.FinishDate.Time.Format(time.DateOnly)
+	}
+	return start, finish
+}
+
+// readHolidaysFile reads a JSON array of dates in YYYY-MM-DD form.
+func readHolidaysFile(filename string) ([]time.Time, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var dates []string
+	err = json.NewDecoder(file).Decode(&dates)
+	if err != nil {
+		return nil, err
+	}
+
+	holidays := make([]time.Time, 0, len(dates))
+	for _, date := range dates {
+		holiday, err := time.Parse(time.DateOnly, date)
+		if err != nil {
+			return nil, fmt.Errorf("invalid holiday %q: %v", date, err)
+		}
+		holidays = append(holidays, holiday)
+	}
+	return holidays, nil
+}
+
+// businessDaysBetween counts the weekdays from start through end, both
+// inclusive, that are not holidays. Only the calendar date of each value is
+// used; iteration dates are date-only values at midnight UTC.
+func businessDaysBetween(start, end time.Time, holidays []time.Time) int {
+	skip := make(map[string]bool, len(holidays))
+	for _, holiday := range holidays {
+		skip[holiday.Format(time.DateOnly)] = true
+	}
+
+	start = time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, time.UTC)
+	end = time.Date(end.Year(), end.Month(), end.Day(), 0, 0, 0, 0, time.UTC)
+
+	days := 0
+	for day := start; !day.After(end); day = day.AddDate(0, 0, 1) {
+		if day.Weekday() == time.Saturday || day.Weekday() == time.Sunday {
+			continue
+		}
+		if skip[day.Format(time.DateOnly)] {
+			continue
+		}
+		days++
+	}
+	return days
+}
+
+// sprintDays returns the number of working days in the iteration: the fixed
+// daysInSprint, or when deriveFromDates is set the business days between the
+// iteration's start and finish date, excluding holidays.
+func sprintDays(iteration work.TeamSettingsIteration, daysInSprint float64, deriveFromDates bool, holidays []time.Time) (float64, error) {
+	if !deriveFromDates {
+		return daysInSprint, nil
+	}
+	start, finish, err := iterationDateRange(iteration)
+	if err != nil {
+		return 0, err
+	}
+	return float64(businessDaysBetween(start, finish, holidays)), nil
+}
+
+// storedSprintDays returns the number of working days of a stored sprint the
+// way sprintDays does for its iteration, from the dates stored in the row.
+func storedSprintDays(row IterationCapacityRow, daysInSprint float64, deriveFromDates bool, holidays []time.Time) (float64, error) {
+	iteration := work.TeamSettingsIteration{Attributes: &work.TeamIterationAttributes{}}
+	if start, err := time.Parse(time.DateOnly, row.StartDate); err == nil {
+		iteration.Attributes.StartDate = &azuredevops.Time{Time: start}
+	}
+	if finish, err := time.Parse(time.DateOnly, row.EndDate); err == nil {
+		iteration.Attributes.FinishDate = &azuredevops.Time{Time: finish}
+	}
+	return sprintDays(iteration, daysInSprint, deriveFromDates, holidays)
+}
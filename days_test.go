@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBusinessDaysBetween(t *testing.T) {
+	date := func(s string) time.Time {
+		d, err := time.Parse(time.DateOnly, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+
+	tests := []struct {
+		name       string
+		start, end string
+		holidays   []string
+		want       int
+	}{
+		{name: "two weeks spanning a weekend", start: "2026-10-05", end: "2026-10-16", want: 10},
+		{name: "holiday on a weekday", start: "2026-10-05", end: "2026-10-16", holidays: []string{"2026-10-12"}, want: 9},
+		{name: "holiday on a weekend", start: "2026-10-05", end: "2026-10-16", holidays: []string{"2026-10-10"}, want: 10},
+		{name: "holiday outside the sprint", start: "2026-10-05", end: "2026-10-16", holidays: []string{"2026-10-19"}, want: 10},
+		{name: "starts and ends on a weekend", start: "2026-10-03", end: "2026-10-11", want: 5},
+		{name: "single day", start: "2026-10-05", end: "2026-10-05", want: 1},
+		{name: "end before start", start: "2026-10-16", end: "2026-10-05", want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var holidays []time.Time
+			for _, holiday := range tt.holidays {
+				holidays = append(holidays, date(holiday))
+			}
+			if got := businessDaysBetween(date(tt.start), date(tt.end), holidays); got != tt.want {
+				t.Errorf("got %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBusinessDaysBetweenIgnoresTimeOfDay(t *testing.T) {
+	// Only the calendar date counts, so a late time on the start date does
+	// not drop the first day
+	start := time.Date(2026, 10, 5, 23, 0, 0, 0, time.UTC)
+	end := time.Date(2026, 10, 9, 0, 0, 0, 0, time.UTC)
+	if got := businessDaysBetween(start, end, nil); got != 5 {
+		t.Errorf("got %d, want 5", got)
+	}
+}
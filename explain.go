@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"slingshot.ninja/devops/iterationcapacity/capacity"
+)
+
+// explainForecasts writes, for every sprint of the team, the stored inputs
+// and the arithmetic that produced its completion ratio and forecast, after
+// the baseline the average was taken over.
+func explainForecasts(w io.Writer, store CapacityStore, team string, args Args) error {
+	rows, err := store.AllRows()
+	if err != nil {
+		return fmt.Errorf("selecting rows: %w", err)
+	}
+	baseline := baselineFromArgs(args)
+	ratios, err := store.CompletionRatios(team, baseline)
+	if err != nil {
+		return fmt.Errorf("selecting completion ratios: %w", err)
+	}
+
+	strategy := args.ForecastStrategy
+	if strategy == "" {
+		strategy = capacity.ForecastStrategyLinear
+	}
+	rounding := args.RoundingMode
+	if rounding == "" {
+		rounding = capacity.RoundingRound
+	}
+	window := "all sprints"
+	if args.AvgWindow > 0 {
+		window = fmt.Sprintf("last %d sprints", args.AvgWindow)
+	}
+	if args.ExcludeCurrentSprint {
+		window += " except the current one"
+	}
+
+	fmt.Fprintf(w, "========== Forecast of team %s ==========\n", team)
+	fmt.Fprintf(w, "Strategy: %s, rounding: %s, average over: %s\n", strategy, rounding, window)
+	if len(ratios) == 0 {
+		fmt.Fprintln(w, "Baseline: no calculated sprints, so no sprint gets a forecast")
+	} else {
+		formatted := make([]string, len(ratios))
+		var sum float64
+		for i, ratio := range ratios {
+			formatted[i] = fmt.Sprintf("%.4f", ratio)
+			sum += ratio
+		}
+		fmt.Fprintf(w, "Baseline: ratios of %d sprints, oldest first: %s\n", len(ratios), strings.Join(formatted, ", "))
+		fmt.Fprintf(w, "  Average ratio: %.4f / %d = %.4f\n", sum, len(ratios), sum/float64(len(ratios)))
+		if strategy == capacity.ForecastStrategyWeighted {
+			fmt.Fprintf(w, "  Weighted average ratio (oldest weight 1, newest weight %d): %.4f\n", len(ratios), capacity.WeightedAverage(ratios))
+		}
+		fmt.Fprintf(w, "  Standard deviation: %.4f\n", capacity.StdDev(ratios))
+	}
+	fmt.Fprintln(w)
+
+	stdDev := capacity.StdDev(ratios)
+	for _, row := range rows {
+		if row.Team != team {
+			continue
+		}
+		fmt.Fprintf(w, "Sprint %d (%s, %s)\n", row.SprintNumber, row.Name, row.Timeframe)
+		switch {
+		case row.CapacityPerDay > 0 && row.DaysOffSubtracted:
+			workingDays := (row.DaysAvailable + float64(row.DaysOff)) / row.CapacityPerDay
+			fmt.Fprintf(w, "  Days available: %.2f capacity per day * %.2f working days - %d days off = %.2f\n",
+				row.CapacityPerDay, workingDays, row.DaysOff, row.DaysAvailable)
+		case row.CapacityPerDay > 0:
+			workingDays := row.DaysAvailable / row.CapacityPerDay
+			fmt.Fprintf(w, "  Days available: %.2f capacity per day * %.2f working days = %.2f (%d days off not subtracted)\n",
+				row.CapacityPerDay, workingDays, row.DaysAvailable, row.DaysOff)
+		default:
+			fmt.Fprintf(w, "  Days available: %.2f (no capacity set)\n", row.DaysAvailable)
+		}
+
+		switch row.RatioStatus {
+		case capacity.RatioCalculated:
+			fmt.Fprintf(w, "  Completion ratio: %g %s / %d whole days available = %.4f\n",
+				row.PointsCompleted, strings.ToLower(unitLabel(row.Unit)), int(row.DaysAvailable), row.PointsCompletedForTotalDays)
+		case capacity.RatioNoCapacity:
+			fmt.Fprintln(w, "  Completion ratio: not calculated, less than one day available")
+		default:
+			fmt.Fprintln(w, "  Completion ratio: not calculated, the completed points are unknown")
+		}
+
+		switch {
+		case row.Timeframe == TimeframePast:
+			fmt.Fprintln(w, "  Forecast: none, the sprint is past")
+		case row.SkipForecast:
+			fmt.Fprintln(w, "  Forecast: none, the sprint is marked skipForecast")
+		case row.ForecastedCompleted == nil:
+			fmt.Fprintln(w, "  Forecast: none, there is no velocity baseline")
+		case row.PointsCompleted > 0 || row.DaysAvailable <= 0:
+			fmt.Fprintf(w, "  Forecast: %d, only sprints without completed points and with days available are forecast\n", *row.ForecastedCompleted)
+		default:
+			ratio, ratioName := *row.AvgPointsCompleted, "average ratio"
+			if strategy == capacity.ForecastStrategyWeighted {
+				ratio, ratioName = capacity.WeightedAverage(ratios), "weighted average ratio"
+			}
+			fmt.Fprintf(w, "  Forecast: %s(%.2f days available * %.4f %s) = %s(%.4f) = %d\n",
+				rounding, row.DaysAvailable, ratio, ratioName, rounding, row.DaysAvailable*ratio, *row.ForecastedCompleted)
+			if row.ForecastLow != nil && row.ForecastHigh != nil {
+				fmt.Fprintf(w, "  Range: %d +/- round(%.2f days available * %.4f standard deviation) = %d to %d\n",
+					*row.ForecastedCompleted, row.DaysAvailable, stdDev, *row.ForecastLow, *row.ForecastHigh)
+			}
+		}
+		if row.ForecastError != nil {
+			fmt.Fprintf(w, "  Forecast error: %g %s completed - %g forecast from the earlier sprints = %g\n",
+				row.PointsCompleted, strings.ToLower(unitLabel(row.Unit)), row.PointsCompleted-*row.ForecastError, *row.ForecastError)
+		}
+		fmt.Fprintln(w)
+	}
+	return nil
+}
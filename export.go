@@ -0,0 +1,440 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/microsoft/azure-devops-go-api/azuredevops/work"
+	"slingshot.ninja/devops/iterationcapacity/capacity"
+)
+
+// IterationCapacityRow is a single row of the capacity table as it is
+// reported to the user.
+type IterationCapacityRow struct {
+	ID                          int                  `json:"id"`
+	Team                        string               `json:"team"`
+	Name                        string               `json:"name"`
+	SprintNumber                int                  `json:"sprintNumber"`
+	DaysAvailable               float64              `json:"daysAvailable"`
+	CapacityPerDay              float64              `json:"capacityPerDay"`
+	DaysOff                     int                  `json:"daysOff"`
+	DaysOffRatio                *float64             `json:"daysOffRatio"`
+	DaysOffSubtracted           bool                 `json:"daysOffSubtracted"`
+	PointsCompleted             float64              `json:"pointsCompleted"`
+	PointsCompletedForTotalDays float64              `json:"pointsCompletedForTotalDays"`
+	RatioStatus                 capacity.RatioStatus `json:"ratioStatus"`
+	AvgPointsCompleted          *float64             `json:"avgPointsCompleted"`
+	ForecastedCompleted         *int64               `json:"forecastedCompleted"`
+	ForecastLow                 *int64               `json:"forecastLow"`
+	ForecastHigh                *int64               `json:"forecastHigh"`
+	ForecastError               *float64             `json:"forecastError"`
+	RunTimestamp                string               `json:"runTimestamp"`
+	Timeframe                   string               `json:"timeframe"`
+	ForecastStrategy            string               `json:"forecastStrategy"`
+	AvgWindow                   *int64               `json:"avgWindow"`
+	SkipForecast                bool                 `json:"skipForecast"`
+	Unit                        string               `json:"unit"`
+	StartDate                   string               `json:"startDate"` // YYYY-MM-DD, "" when unknown
+	EndDate                     string               `json:"endDate"`
+	// RawCapacity is the capacity response the row was computed from, when
+	// stored with -store-raw. Only the CSV export includes it.
+	RawCapacity string `json:"-"`
+}
+
+// dateOrUnknown returns the date, or "unknown" when it is not set.
+func dateOrUnknown(date string) string {
+	if date == "" {
+		return "unknown"
+	}
+	return date
+}
+
+// printRows writes the rows in the human readable report format, followed
+// by a summary in which the baseline selects the sprints of the efficiency
+// and the histogram.
+func printRows(w io.Writer, rows []IterationCapacityRow, baseline Baseline) {
+	for _, row := range rows {
+		fmt.Fprintf(w, "ID: %d\n", row.ID)
+		fmt.Fprintf(w, "Team: %s\n", row.Team)
+		fmt.Fprintf(w, "Sprint: %d\n", row.SprintNumber)
+		fmt.Fprintf(w, "Name: %s\n", row.Name)
+		fmt.Fprintf(w, "Timeframe: %s\n", row.Timeframe)
+		if row.StartDate != "" || row.EndDate != "" {
+			fmt.Fprintf(w, "Dates: %s - %s\n", dateOrUnknown(row.StartDate), dateOrUnknown(row.EndDate))
+		}
+		fmt.Fprintf(w, "Days Available: %f\n", row.DaysAvailable)
+		fmt.Fprintf(w, "Capacity Per Day: %f\n", row.CapacityPerDay)
+		if row.DaysOffSubtracted {
+			fmt.Fprintf(w, "Days Off: %d\n", row.DaysOff)
+		} else {
+			fmt.Fprintf(w, "Days Off: %d (not subtracted)\n", row.DaysOff)
+		}
+		if row.DaysOffRatio != nil {
+			fmt.Fprintf(w, "Days Off Ratio: %f\n", *row.DaysOffRatio)
+		} else {
+			fmt.Fprintln(w, "Days Off Ratio: NULL")
+		}
+		fmt.Fprintf(w, "%s Completed: %g\n", unitLabel(row.Unit), row.PointsCompleted)
+		fmt.Fprintf(w, "%s Completed vs Days Available: %f (%s)\n", unitLabel(row.Unit), row.PointsCompletedForTotalDays, row.RatioStatus)
+		if row.AvgPointsCompleted != nil {
+			fmt.Fprintf(w, "Avg Completed vs Capacity: %f\n", *row.AvgPointsCompleted)
+		} else {
+			fmt.Fprintln(w, "Avg Completed vs Capacity: NULL")
+		}
+		if row.ForecastedCompleted != nil {
+			fmt.Fprintf(w, "Forcasted: %d\n", *row.ForecastedCompleted)
+		} else {
+			fmt.Fprintln(w, "Forcasted: NULL")
+		}
+		if row.ForecastLow != nil && row.ForecastHigh != nil {
+			fmt.Fprintf(w, "Forecast Range: %d - %d\n", *row.ForecastLow, *row.ForecastHigh)
+		}
+		if row.ForecastError != nil {
+			fmt.Fprintf(w, "Forecast Error: %g\n", *row.ForecastError)
+		}
+		if row.SkipForecast {
+			fmt.Fprintln(w, "Skip Forecast: true")
+		}
+		if row.ForecastStrategy != "" && row.AvgWindow != nil {
+			fmt.Fprintf(w, "Forecast Strategy: %s (avg window %d)\n", row.ForecastStrategy, *row.AvgWindow)
+		}
+		fmt.Fprintf(w, "Run: %s\n", row.RunTimestamp)
+		fmt.Fprintln(w)
+	}
+	printSummary(w, rows, baseline)
+}
+
+// unitLabel returns the unit for report headers, capitalised, such as
+// "Hours". Rows without a unit count points.
+func unitLabel(unit string) string {
+	if unit == "" {
+		unit = defaultUnit
+	}
+	first, size := utf8.DecodeRuneInString(unit)
+	return string(unicode.ToUpper(first)) + unit[size:]
+}
+
+// printSummary writes the totals over all rows. Sprints without known
+// completed points (stored as -1) and rows without a forecast do not add to
+// the respective totals. The overall efficiency is the points completed per
+// day available over the sprints of the baseline together, and the forecast
+// MAPE is taken over the sprints with a forecast error.
+func printSummary(w io.Writer, rows []IterationCapacityRow, baseline Baseline) {
+	var daysAvailable float64
+	var pointsCompleted float64
+	var forecasted int64
+	var calculatedPoints, calculatedDays float64
+	var actuals, forecastErrors []float64
+	label := ""
+	for i, row := range rows {
+		if i == 0 {
+			label = unitLabel(row.Unit)
+		} else if unitLabel(row.Unit) != label {
+			label = "Amount"
+		}
+		daysAvailable += row.DaysAvailable
+		if row.PointsCompleted > 0 {
+			pointsCompleted += row.PointsCompleted
+		}
+		if row.ForecastedCompleted != nil {
+			forecasted += *row.ForecastedCompleted
+		}
+		if baseline.includes(row) {
+			calculatedPoints += row.PointsCompleted
+			calculatedDays += row.DaysAvailable
+		}
+		if row.ForecastError != nil {
+			actuals = append(actuals, row.PointsCompleted)
+			forecastErrors = append(forecastErrors, *row.ForecastError)
+		}
+	}
+
+	fmt.Fprintln(w, "========== Summary ==========")
+	fmt.Fprintf(w, "Sprints Processed: %d\n", len(rows))
+	fmt.Fprintf(w, "Total Days Available: %f\n", daysAvailable)
+	if label == "" {
+		label = unitLabel("")
+	}
+	fmt.Fprintf(w, "Total %s Completed: %g\n", label, pointsCompleted)
+	fmt.Fprintf(w, "Total Forecasted: %d\n", forecasted)
+	if calculatedDays > 0 {
+		fmt.Fprintf(w, "Overall Efficiency: %f (%g %s / %g days available)\n", calculatedPoints/calculatedDays, calculatedPoints, strings.ToLower(label), calculatedDays)
+	} else {
+		fmt.Fprintln(w, "Overall Efficiency: NULL")
+	}
+	if mape, count := capacity.MAPE(actuals, forecastErrors); count > 0 {
+		fmt.Fprintf(w, "Forecast MAPE: %.1f%% (over %d sprints)\n", mape, count)
+	} else {
+		fmt.Fprintln(w, "Forecast MAPE: NULL")
+	}
+	printHistogram(w, rows, baseline)
+}
+
+// Bucket width and longest bar of the completion ratio histogram.
+const (
+	histogramBucket = 0.25
+	histogramWidth  = 40
+)
+
+// printHistogram writes the completion ratios of the sprints of the baseline
+// as ASCII bars in buckets of histogramBucket, from the lowest to the highest
+// ratio, so the spread of the velocity shows at a glance. Future sprints
+// listed with zero points are not part of the baseline and would show a
+// spread that is not there.
+func printHistogram(w io.Writer, rows []IterationCapacityRow, baseline Baseline) {
+	counts := make(map[int]int)
+	first, last, highest := 0, 0, 0
+	for _, row := range rows {
+		if !baseline.includes(row) {
+			continue
+		}
+		bucket := int(row.PointsCompletedForTotalDays / histogramBucket)
+		if len(counts) == 0 || bucket < first {
+			first = bucket
+		}
+		if len(counts) == 0 || bucket > last {
+			last = bucket
+		}
+		counts[bucket]++
+		highest = max(highest, counts[bucket])
+	}
+	if len(counts) == 0 {
+		return
+	}
+
+	fmt.Fprintln(w, "Completion ratio histogram:")
+	for bucket := first; bucket <= last; bucket++ {
+		low := float64(bucket) * histogramBucket
+		bar := strings.Repeat("#", (counts[bucket]*histogramWidth+highest-1)/highest)
+		fmt.Fprintf(w, "  %.2f-%.2f | %-*s %d\n", low, low+histogramBucket, histogramWidth, bar, counts[bucket])
+	}
+}
+
+// printIterations writes a table of the iterations with their parsed sprint
+// number, so sprintStart and sprintNameRegex can be chosen before a run.
+func printIterations(w io.Writer, iterations []work.TeamSettingsIteration, sprintPattern sprintNamePattern) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tSPRINT\tID\tPATH")
+	for _, iteration := range iterations {
+		sprint := "unparseable"
+		if sprintNum, err := extractSprintNumber(iteration.Name, sprintPattern); err == nil {
+			sprint = strconv.Itoa(sprintNum)
+		}
+		id := ""
+		if iteration.Id != nil {
+			id = iteration.Id.String()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", iterationName(iteration), sprint, id, iterationPath(iteration))
+	}
+	tw.Flush()
+}
+
+// printStoreSchema writes the CREATE TABLE statements followed by a
+// description of every column of the capacity table.
+func printStoreSchema(w io.Writer, statements []string) {
+	for _, statement := range statements {
+		fmt.Fprintf(w, "%s;\n\n", statement)
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "COLUMN\tDESCRIPTION")
+	for _, column := range columnDescriptions {
+		fmt.Fprintf(tw, "%s\t%s\n", column[0], column[1])
+	}
+	tw.Flush()
+}
+
+// writeJSON writes the rows as an indented JSON array.
+func writeJSON(w io.Writer, rows []IterationCapacityRow) error {
+	if rows == nil {
+		rows = []IterationCapacityRow{}
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(rows)
+}
+
+// csvFloatPrecision is the number of decimals written for REAL columns so the
+// exported values do not depend on how SQLite happens to store them.
+const csvFloatPrecision = 4
+
+func formatCSVFloat(value float64) string {
+	return strconv.FormatFloat(value, 'f', csvFloatPrecision, 64)
+}
+
+// exportCSV writes the rows of the capacity table to path as CSV, preceded
+// by a header row with the column names.
+func exportCSV(rows []IterationCapacityRow, path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	err = writer.Write([]string{
+		"id",
+		"name",
+		"sprint_number",
+		"days_available",
+		"capacity_per_day",
+		"days_off",
+		"points_completed",
+		"pnts_complete_for_totaldays",
+		"avg_pnts_complete",
+		"forecasted_completed",
+		"team",
+		"run_timestamp",
+		"ratio_status",
+		"timeframe",
+		"forecast_strategy",
+		"avg_window",
+		"skip_forecast",
+		"forecast_low",
+		"forecast_high",
+		"forecast_error",
+		"raw_capacity",
+		"unit",
+		"days_off_ratio",
+		"days_off_subtracted",
+		"start_date",
+		"end_date",
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		forecast := ""
+		if row.ForecastedCompleted != nil {
+			forecast = strconv.FormatInt(*row.ForecastedCompleted, 10)
+		}
+		avgWindow := ""
+		if row.AvgWindow != nil {
+			avgWindow = strconv.FormatInt(*row.AvgWindow, 10)
+		}
+		avgCompleted := ""
+		if row.AvgPointsCompleted != nil {
+			avgCompleted = formatCSVFloat(*row.AvgPointsCompleted)
+		}
+		forecastError := ""
+		if row.ForecastError != nil {
+			forecastError = formatCSVFloat(*row.ForecastError)
+		}
+		daysOffRatio := ""
+		if row.DaysOffRatio != nil {
+			daysOffRatio = formatCSVFloat(*row.DaysOffRatio)
+		}
+		forecastLow, forecastHigh := "", ""
+		if row.ForecastLow != nil && row.ForecastHigh != nil {
+			forecastLow = strconv.FormatInt(*row.ForecastLow, 10)
+			forecastHigh = strconv.FormatInt(*row.ForecastHigh, 10)
+		}
+
+		err = writer.Write([]string{
+			strconv.Itoa(row.ID),
+			row.Name,
+			strconv.Itoa(row.SprintNumber),
+			formatCSVFloat(row.DaysAvailable),
+			formatCSVFloat(row.CapacityPerDay),
+			strconv.Itoa(row.DaysOff),
+			formatCSVFloat(row.PointsCompleted),
+			formatCSVFloat(row.PointsCompletedForTotalDays),
+			avgCompleted,
+			forecast,
+			row.Team,
+			row.RunTimestamp,
+			string(row.RatioStatus),
+			row.Timeframe,
+			row.ForecastStrategy,
+			avgWindow,
+			strconv.FormatBool(row.SkipForecast),
+			forecastLow,
+			forecastHigh,
+			forecastError,
+			row.RawCapacity,
+			row.Unit,
+			daysOffRatio,
+			strconv.FormatBool(row.DaysOffSubtracted),
+			row.StartDate,
+			row.EndDate,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// runDirLayout names the timestamped folder of a run in -output-dir. It sorts
+// chronologically and avoids the colons of RFC 3339, which Windows rejects.
+const runDirLayout = "20060102T150405Z"
+
+// exportRunDir writes iterations.csv, iterations.json and, when sqlitePath is
+// set, a copy of the SQLite database to a new folder in dir named after
+// started. An existing folder is never overwritten. It returns the folder.
+func exportRunDir(dir string, started time.Time, rows []IterationCapacityRow, sqlitePath string) (string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	runDir := filepath.Join(dir, started.UTC().Format(runDirLayout))
+	if err := os.Mkdir(runDir, 0o755); err != nil {
+		return "", err
+	}
+
+	if err := exportCSV(rows, filepath.Join(runDir, "iterations.csv")); err != nil {
+		return "", err
+	}
+
+	file, err := os.Create(filepath.Join(runDir, "iterations.json"))
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	if err := writeJSON(file, rows); err != nil {
+		return "", err
+	}
+	if err := file.Close(); err != nil {
+		return "", err
+	}
+
+	if sqlitePath != "" {
+		if err := copyFile(sqlitePath, filepath.Join(runDir, filepath.Base(sqlitePath))); err != nil {
+			return "", fmt.Errorf("copying database: %w", err)
+		}
+	}
+	return runDir, nil
+}
+
+// copyFile copies the file at src to a new file at dst.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	if _, err := io.Copy(out, in); err != nil {
+		return err
+	}
+	return out.Close()
+}
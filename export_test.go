@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"slingshot.ninja/devops/iterationcapacity/capacity"
+)
+
+// summaryTestRows are two past sprints with points, a future sprint listed
+// with zero points and calculate: true, and a sprint marked to skip the
+// forecast.
+var summaryTestRows = []IterationCapacityRow{
+	{Team: "Team A", SprintNumber: 1, DaysAvailable: 20, PointsCompleted: 20, PointsCompletedForTotalDays: 1, RatioStatus: capacity.RatioCalculated, Timeframe: TimeframePast},
+	{Team: "Team A", SprintNumber: 2, DaysAvailable: 18, PointsCompleted: 30, PointsCompletedForTotalDays: 30.0 / 18, RatioStatus: capacity.RatioCalculated, Timeframe: TimeframePast},
+	{Team: "Team A", SprintNumber: 3, DaysAvailable: 19, PointsCompleted: 0, RatioStatus: capacity.RatioCalculated, Timeframe: TimeframeFuture},
+	{Team: "Team A", SprintNumber: 4, DaysAvailable: 10, PointsCompleted: 40, PointsCompletedForTotalDays: 4, RatioStatus: capacity.RatioCalculated, Timeframe: TimeframePast, SkipForecast: true},
+}
+
+func TestPrintHistogramBaselineSprints(t *testing.T) {
+	var b strings.Builder
+	printHistogram(&b, summaryTestRows, Baseline{})
+	got := b.String()
+	if strings.Contains(got, "0.00-0.25") {
+		t.Errorf("the future sprint without points is counted:\n%s", got)
+	}
+	if strings.Contains(got, "4.00-4.25") {
+		t.Errorf("the skipped sprint is counted:\n%s", got)
+	}
+	if !strings.Contains(got, "1.00-1.25 | ") || !strings.Contains(got, "1.50-1.75 | ") {
+		t.Errorf("the past sprints are missing:\n%s", got)
+	}
+
+	b.Reset()
+	printHistogram(&b, summaryTestRows, Baseline{IncludeZeroPoints: true})
+	if !strings.Contains(b.String(), "0.00-0.25 | ") {
+		t.Errorf("the zero point sprint is missing with IncludeZeroPoints:\n%s", b.String())
+	}
+}
+
+func TestPrintSummaryEfficiency(t *testing.T) {
+	tests := []struct {
+		name     string
+		baseline Baseline
+		want     string
+	}{
+		{name: "default baseline", baseline: Baseline{}, want: "Overall Efficiency: 1.315789 (50 points / 38 days available)"},
+		{name: "zero point sprints included", baseline: Baseline{IncludeZeroPoints: true}, want: "Overall Efficiency: 0.877193 (50 points / 57 days available)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b strings.Builder
+			printSummary(&b, summaryTestRows, tt.baseline)
+			if !strings.Contains(b.String(), tt.want+"\n") {
+				t.Errorf("summary lacks %q:\n%s", tt.want, b.String())
+			}
+		})
+	}
+}
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// goldenRows stores known sprints in a new database, computes their averages
+// and forecasts and returns the rows as the reports read them.
+func goldenRows(t *testing.T) []IterationCapacityRow {
+	t.Helper()
+	store := newTestStore(t)
+	ratio := 0.1
+	rows := []IterationCapacityRow{
+		{Name: "Sprint 11", SprintNumber: 11, DaysAvailable: 18, CapacityPerDay: 2, DaysOff: 2, DaysOffRatio: &ratio, PointsCompleted: 27, Timeframe: TimeframePast, StartDate: "2026-08-24", EndDate: "2026-09-04"},
+		{Name: "Sprint 12", SprintNumber: 12, DaysAvailable: 20, CapacityPerDay: 2, PointsCompleted: 22.5, Timeframe: TimeframePast, StartDate: "2026-09-07", EndDate: "2026-09-18",
+			RawCapacity: `{"teams":[],"totalIterationCapacityPerDay":2,"totalIterationDaysOff":0}`},
+		{Name: "Sprint 13", SprintNumber: 13, DaysAvailable: 19.5, CapacityPerDay: 1.95, PointsCompleted: 40, Timeframe: TimeframePast, SkipForecast: true},
+		{Name: "Sprint 14", SprintNumber: 14, DaysAvailable: 16, CapacityPerDay: 2, DaysOff: 4, PointsCompleted: -1, Timeframe: TimeframeCurrent, StartDate: "2026-10-05", EndDate: "2026-10-16"},
+		{Name: "Sprint 15", SprintNumber: 15, PointsCompleted: -1, Timeframe: TimeframeFuture},
+	}
+	var calculator capacity.Calculator
+	for _, row := range rows {
+		row.Team = "Team A"
+		row.Unit = defaultUnit
+		row.RunTimestamp = "2026-10-16T09:30:00Z"
+		row.DaysOffSubtracted = true
+		row.PointsCompletedForTotalDays, row.RatioStatus = calculator.CompletionRatio(row.PointsCompleted, row.DaysAvailable)
+		if err := store.InsertRow(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := updateForecasts(store, "Team A", Args{}); err != nil {
+		t.Fatal(err)
+	}
+	result, err := store.AllRows()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+// checkGolden compares the output with the golden file in testdata, or
+// rewrites the file when the tests run with -update.
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading golden file: %v; run go test -update to create it", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("output differs from %s; run go test -update if the change is intended\ngot:\n%s\nwant:\n%s", path, got, want)
+	}
+}
+
+func TestExportCSVGolden(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "iterations.csv")
+	if err := exportCSV(goldenRows(t), path); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "export.csv", got)
+}
+
+func TestWriteJSONGolden(t *testing.T) {
+	var b bytes.Buffer
+	if err := writeJSON(&b, goldenRows(t)); err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "export.json", b.Bytes())
+}
+
+func TestUnitLabel(t *testing.T) {
+	tests := []struct{ unit, want string }{
+		{"", "Points"},
+		{"hours", "Hours"},
+		{"ürün", "Ürün"},
+		{"été", "Été"},
+	}
+	for _, tt := range tests {
+		if got := unitLabel(tt.unit); got != tt.want {
+			t.Errorf("unitLabel(%q) = %q, want %q", tt.unit, got, tt.want)
+		}
+	}
+}
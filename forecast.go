@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+
+	"slingshot.ninja/devops/iterationcapacity/capacity"
+)
+
+// newForecaster builds the forecaster for the strategy. ratios are the
+// completion ratios of the team's baseline, oldest first, for the strategies
+// that weigh them. The forecasts are converted to whole points with the
+// rounding mode.
+func newForecaster(strategy string, ratios []float64, rounding capacity.RoundingMode) (capacity.Forecaster, error) {
+	switch strategy {
+	case "", capacity.ForecastStrategyLinear:
+		return capacity.LinearForecaster{Rounding: rounding}, nil
+	case capacity.ForecastStrategyWeighted:
+		return capacity.WeightedMovingAverageForecaster{Ratios: ratios, Rounding: rounding}, nil
+	default:
+		return nil, capacity.ValidateForecastStrategy(strategy)
+	}
+}
+
+// baselineFromArgs returns the baseline selected by the arguments.
+func baselineFromArgs(args Args) Baseline {
+	return Baseline{
+		Window:            args.AvgWindow,
+		IncludeZeroPoints: args.IncludeZeroPointSprints,
+		ExcludeCurrent:    args.ExcludeCurrentSprint,
+	}
+}
+
+// updateForecasts recomputes the averages and forecasts of the team's rows
+// from the completion ratios of its baseline, using the forecast settings of
+// args.
+func updateForecasts(store CapacityStore, team string, args Args) error {
+	baseline := baselineFromArgs(args)
+	slog.Info("Determine the average of Completed vs Capacity")
+	if err := store.UpdateAverages(team, baseline); err != nil {
+		return fmt.Errorf("updating averages: %w", err)
+	}
+
+	slog.Info("Determine the Forecasted Completed")
+	ratios, err := store.CompletionRatios(team, baseline)
+	if err != nil {
+		return fmt.Errorf("selecting completion ratios: %w", err)
+	}
+
+	// Without a calculated sprint the average is NULL. The forecasts are then
+	// left empty rather than derived from a zero velocity.
+	var calculator capacity.Calculator
+	if len(ratios) == 0 {
+		slog.Warn("No calculated sprints, so there is no velocity baseline; skipping the forecast", "team", team)
+	} else {
+		forecaster, err := newForecaster(args.ForecastStrategy, ratios, args.RoundingMode)
+		if err != nil {
+			return fmt.Errorf("preparing forecast: %w", err)
+		}
+		calculator.Forecaster = forecaster
+		calculator.StdDev = capacity.StdDev(ratios)
+	}
+	strategy := args.ForecastStrategy
+	if strategy == "" {
+		strategy = capacity.ForecastStrategyLinear
+	}
+
+	rows, err := store.AllRows()
+	if err != nil {
+		return fmt.Errorf("selecting rows: %w", err)
+	}
+	forecastErrors, err := backtestErrors(rows, team, args)
+	if err != nil {
+		return fmt.Errorf("preparing forecast errors: %w", err)
+	}
+	return store.UpdateForecast(team, calculator, strategy, args.AvgWindow, forecastErrors)
+}
+
+// backtestErrors returns, by sprint number, the forecast error of every past
+// sprint of the team whose completion ratio was calculated: its completed
+// points minus the forecast from its days available that the sprints of the
+// baseline before it would have given, with the window and strategy of args.
+// The sprint's own and later ratios are left out, so the errors measure how
+// well the sprint could have been forecast. Sprints without an earlier
+// sprint in the baseline get no error. The rows are ordered by sprint number.
+func backtestErrors(rows []IterationCapacityRow, team string, args Args) (map[int]float64, error) {
+	baseline := baselineFromArgs(args)
+	forecastErrors := make(map[int]float64)
+	var earlier []float64
+	for _, row := range rows {
+		if row.Team != team {
+			continue
+		}
+		if row.Timeframe == TimeframePast && !row.SkipForecast && row.RatioStatus == capacity.RatioCalculated && len(earlier) > 0 {
+			ratios := earlier
+			if baseline.Window > 0 && len(ratios) > baseline.Window {
+				ratios = ratios[len(ratios)-baseline.Window:]
+			}
+			forecaster, err := newForecaster(args.ForecastStrategy, ratios, args.RoundingMode)
+			if err != nil {
+				return nil, err
+			}
+			var sum float64
+			for _, ratio := range ratios {
+				sum += ratio
+			}
+			forecast := forecaster.Forecast(row.DaysAvailable, 0, sum/float64(len(ratios)))
+			forecastErrors[row.SprintNumber] = row.PointsCompleted - float64(forecast)
+		}
+		if baseline.includes(row) {
+			earlier = append(earlier, row.PointsCompletedForTotalDays)
+		}
+	}
+	return forecastErrors, nil
+}
@@ -1,10 +1,12 @@
 module slingshot.ninja/devops/iterationcapacity
 
-go 1.20
+go 1.21
 
 require github.com/microsoft/azure-devops-go-api/azuredevops v1.0.0-b5
 
 require (
-	github.com/google/uuid v1.3.0 // indirect
+	github.com/google/uuid v1.3.0
+	github.com/lib/pq v1.10.9
 	github.com/mattn/go-sqlite3 v1.14.16
+	gopkg.in/yaml.v3 v3.0.1
 )
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"os"
+)
+
+// htmlReportTemplate renders the rows as a table. html/template escapes every
+// value, including team and iteration names taken from Azure DevOps.
+var htmlReportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Iteration capacity</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; }
+th, td { padding: 0.3em 0.8em; border-bottom: 1px solid #ddd; text-align: right; }
+th { background: #f4f4f4; }
+td.text { text-align: left; }
+.bar { height: 0.6em; margin: 0.1em 0; }
+.completed { background: #4a90d9; }
+.forecast { background: #f0a030; }
+.legend span { display: inline-block; width: 1em; height: 0.6em; margin: 0 0.3em 0 1em; }
+</style>
+</head>
+<body>
+<h1>Iteration capacity</h1>
+<p class="legend"><span class="completed"></span>Completed<span class="forecast"></span>Forecasted</p>
+<table>
+<tr>
+<th>Team</th><th>Sprint</th><th>Name</th><th>Days Available</th><th>Capacity Per Day</th><th>Days Off</th>
+<th>{{.Unit}} Completed</th><th>Completed vs Days Available</th><th>Avg Completed vs Capacity</th><th>Forecasted</th><th></th>
+</tr>
+{{- range .Rows}}
+<tr>
+<td class="text">{{.Team}}</td>
+<td>{{.SprintNumber}}</td>
+<td class="text">{{.Name}}</td>
+<td>{{printf "%.2f" .DaysAvailable}}</td>
+<td>{{printf "%.2f" .CapacityPerDay}}</td>
+<td>{{.DaysOff}}</td>
+<td>{{if ge .PointsCompleted 0.0}}{{.PointsCompleted}}{{else}}&ndash;{{end}}</td>
+<td>{{printf "%.4f" .PointsCompletedForTotalDays}} ({{.RatioStatus}})</td>
+<td>{{with .AvgPointsCompleted}}{{printf "%.4f" .}}{{else}}&ndash;{{end}}</td>
+<td>{{if .HasForecast}}{{.Forecast}}{{else}}&ndash;{{end}}</td>
+<td class="text" style="width: 200px">
+{{- if .CompletedWidth}}<div class="bar completed" style="width: {{.CompletedWidth}}%"></div>{{end}}
+{{- if .ForecastWidth}}<div class="bar forecast" style="width: {{.ForecastWidth}}%"></div>{{end -}}
+</td>
+</tr>
+{{- end}}
+</table>
+</body>
+</html>
+`))
+
+// htmlReport is the data of the HTML report: the rows and the unit label of
+// their headers.
+type htmlReport struct {
+	Unit string
+	Rows []htmlReportRow
+}
+
+// htmlReportRow is a row of the HTML report with the widths of its bars, as
+// a percentage of the largest completed or forecasted value in the report.
+type htmlReportRow struct {
+	IterationCapacityRow
+	// HasForecast tells a forecast of zero from a missing forecast, which is
+	// shown as a dash.
+	HasForecast    bool
+	Forecast       int64
+	CompletedWidth int
+	ForecastWidth  int
+}
+
+// htmlReportRows prepares the rows for the template.
+func htmlReportRows(rows []IterationCapacityRow) []htmlReportRow {
+	var max float64
+	for _, row := range rows {
+		if row.PointsCompleted > max {
+			max = row.PointsCompleted
+		}
+		if row.ForecastedCompleted != nil && float64(*row.ForecastedCompleted) > max {
+			max = float64(*row.ForecastedCompleted)
+		}
+	}
+
+	width := func(value float64) int {
+		if max <= 0 || value <= 0 {
+			return 0
+		}
+		return int(value / max * 100)
+	}
+
+	result := make([]htmlReportRow, 0, len(rows))
+	for _, row := range rows {
+		reportRow := htmlReportRow{
+			IterationCapacityRow: row,
+			CompletedWidth:       width(row.PointsCompleted),
+		}
+		if row.ForecastedCompleted != nil {
+			reportRow.HasForecast = true
+			reportRow.Forecast = *row.ForecastedCompleted
+			reportRow.ForecastWidth = width(float64(*row.ForecastedCompleted))
+		}
+		result = append(result, reportRow)
+	}
+	return result
+}
+
+// exportHTML writes the rows as a self-contained HTML report to path.
+func exportHTML(rows []IterationCapacityRow, path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	unit := unitLabel("")
+	if len(rows) > 0 {
+		unit = unitLabel(rows[0].Unit)
+	}
+	report := htmlReport{Unit: unit, Rows: htmlReportRows(rows)}
+	if err := htmlReportTemplate.Execute(file, report); err != nil {
+		return fmt.Errorf("rendering HTML report: %w", err)
+	}
+	return file.Close()
+}
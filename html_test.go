@@ -0,0 +1,39 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExportHTMLForecasts(t *testing.T) {
+	zero, five := int64(0), int64(5)
+	rows := []IterationCapacityRow{
+		{Team: "Team A", SprintNumber: 1, Name: "Sprint 1", PointsCompleted: 10},
+		{Team: "Team A", SprintNumber: 2, Name: "Sprint 2", PointsCompleted: -1, ForecastedCompleted: &zero},
+		{Team: "Team A", SprintNumber: 3, Name: "Sprint 3", PointsCompleted: -1, ForecastedCompleted: &five},
+	}
+	path := filepath.Join(t.TempDir(), "report.html")
+	if err := exportHTML(rows, path); err != nil {
+		t.Fatal(err)
+	}
+	body, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The forecast is the cell before the one with the bars
+	var forecasts []string
+	var previous string
+	for _, line := range strings.Split(string(body), "\n") {
+		if strings.HasPrefix(line, `<td class="text" style="width: 200px">`) {
+			forecasts = append(forecasts, previous)
+		}
+		previous = line
+	}
+	want := []string{"<td>&ndash;</td>", "<td>0</td>", "<td>5</td>"}
+	if strings.Join(forecasts, " ") != strings.Join(want, " ") {
+		t.Errorf("forecast cells = %q, want %q", forecasts, want)
+	}
+}
@@ -2,45 +2,92 @@ package main
 
 import (
 	"context"
-	"database/sql"
-	"encoding/base64"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
-	"math"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
 	"regexp"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/microsoft/azure-devops-go-api/azuredevops"
 	"github.com/microsoft/azure-devops-go-api/azuredevops/work"
-
-	_ "github.com/mattn/go-sqlite3"
+	"gopkg.in/yaml.v3"
+	"slingshot.ninja/devops/iterationcapacity/capacity"
 )
 
-type CapacityData struct {
-	Teams                        []TeamData `json:"teams"`
-	TotalIterationCapacityPerDay float64    `json:"totalIterationCapacityPerDay"`
-	TotalIterationDaysOff        int        `json:"totalIterationDaysOff"`
+// defaultSprintNameRegex matches iteration names such as "Sprint 67".
+const defaultSprintNameRegex = `Sprint\s+(\d+)`
+
+// sprintNamePattern parses sprint numbers from iteration names.
+type sprintNamePattern struct {
+	re *regexp.Regexp
+	// piMultiplier, when positive, combines two capture groups, a program
+	// increment and a sprint within it, into PI * piMultiplier + sprint.
+	piMultiplier int
 }
 
-type TeamData struct {
-	TeamId             string  `json:"teamId"`
-	TeamCapacityPerDay float64 `json:"teamCapacityPerDay"`
-	TeamTotalDaysOff   int     `json:"teamTotalDaysOff"`
+// compileSprintRegex compiles the sprint name pattern, falling back to the
+// default when it is empty. The first capture group holds the sprint number;
+// with a positive piMultiplier the first group holds the program increment
+// and the second the sprint within it.
+func compileSprintRegex(pattern string, piMultiplier int) (sprintNamePattern, error) {
+	if pattern == "" {
+		pattern = defaultSprintNameRegex
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return sprintNamePattern{}, fmt.Errorf("invalid sprint name regex %q: %v", pattern, err)
+	}
+	if re.NumSubexp() < 1 {
+		return sprintNamePattern{}, fmt.Errorf("sprint name regex %q must contain a capture group for the sprint number", pattern)
+	}
+	if piMultiplier > 0 && re.NumSubexp() < 2 {
+		return sprintNamePattern{}, fmt.Errorf("sprint name regex %q must contain capture groups for the PI and the sprint number when piMultiplier is set", pattern)
+	}
+	return sprintNamePattern{re: re, piMultiplier: piMultiplier}, nil
 }
 
-func extractSprintNumber(iterationName *string) (int, error) {
+func extractSprintNumber(iterationName *string, pattern sprintNamePattern) (int, error) {
 	if iterationName == nil {
 		return 0, fmt.Errorf("iteration name is nil")
 	}
-	re := regexp.MustCompile(`Sprint\s+(\d+)`)
-	matches := re.FindStringSubmatch(*iterationName)
+	matches := pattern.re.FindStringSubmatch(*iterationName)
 	if len(matches) < 2 {
 		return 0, fmt.Errorf("iteration name does not contain sprint number")
 	}
 
+	if pattern.piMultiplier > 0 {
+		pi, err := strconv.Atoi(matches[1])
+		if err != nil {
+			return 0, fmt.Errorf("could not parse PI number from iteration name: %v", err)
+		}
+		sprint, err := strconv.Atoi(matches[2])
+		if err != nil {
+			return 0, fmt.Errorf("could not parse sprint number from iteration name: %v", err)
+		}
+		// A sprint at or past the multiplier would overlap the next PI
+		if sprint >= pattern.piMultiplier {
+			return 0, fmt.Errorf("sprint %d is not below piMultiplier %d", sprint, pattern.piMultiplier)
+		}
+		return pi*pattern.piMultiplier + sprint, nil
+	}
+
 	sprintNum, err := strconv.Atoi(matches[1])
 	if err != nil {
 		return 0, fmt.Errorf("could not parse sprint number from iteration name: %v", err)
@@ -49,85 +96,395 @@ func extractSprintNumber(iterationName *string) (int, error) {
 	return sprintNum, nil
 }
 
-func createAuthHeader(patToken string) string {
-	encoded := base64.StdEncoding.EncodeToString([]byte(":" + patToken))
-	return "Basic " + encoded
+// iterationName returns the iteration's name for log messages, which may be
+// missing from malformed API responses.
+func iterationName(iteration work.TeamSettingsIteration) string {
+	if iteration.Name == nil {
+		return "<unnamed>"
+	}
+	return *iteration.Name
 }
 
-func fetchIterationCapacity(connection *azuredevops.Connection, patToken, project, iterationID string) (CapacityData, error) {
-	ctx := context.Background()
-	client := &http.Client{}
+// iterationPath returns the path of the iteration, or "" when Azure DevOps
+// did not report one.
+func iterationPath(iteration work.TeamSettingsIteration) string {
+	if iteration.Path == nil {
+		return ""
+	}
+	return *iteration.Path
+}
 
-	// Build URL for the capacity API
-	capacitiesAPIURL := fmt.Sprintf("%s/%s/_apis/work/iterations/%s/iterationcapacities?api-version=7.0", connection.BaseUrl, project, iterationID)
+// Stable values of the timeframe column. Azure DevOps reports whether an
+// iteration lies in the past, is the current one or lies in the future;
+// iterations without that attribute are stored as TimeframeUnknown.
+const (
+	TimeframePast    = "past"
+	TimeframeCurrent = "current"
+	TimeframeFuture  = "future"
+	TimeframeUnknown = "unknown"
+)
 
-	// Create a new HTTP request with the correct headers
-	req, err := http.NewRequestWithContext(ctx, "GET", capacitiesAPIURL, nil)
-	if err != nil {
-		return CapacityData{}, err
+// TimeframeAll selects the iterations of every time frame in the timeframe
+// argument; it is never stored.
+const TimeframeAll = "all"
+
+// iterationTimeframe maps the iteration's time frame attribute to one of the
+// Timeframe values.
+func iterationTimeframe(iteration work.TeamSettingsIteration) string {
+	if iteration.Attributes == nil || iteration.Attributes.TimeFrame == nil {
+		return TimeframeUnknown
+	}
+	switch timeframe := strings.ToLower(string(*iteration.Attributes.TimeFrame)); timeframe {
+	case TimeframePast, TimeframeCurrent, TimeframeFuture:
+		return timeframe
+	default:
+		return TimeframeUnknown
 	}
+}
 
-	// Add authorization header
-	authHeader := createAuthHeader(patToken)
-	req.Header.Set("Authorization", authHeader)
+// setupLogger configures the default slog logger to write structured logs
+// to stderr at the given level (debug, info, warn or error). Records step
+// around the progress counter, which shares stderr.
+func setupLogger(level string) error {
+	var logLevel slog.Level
+	if err := logLevel.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("invalid log level %q: %v", level, err)
+	}
+	handler := slog.NewTextHandler(progressLogWriter{w: os.Stderr}, &slog.HandlerOptions{Level: logLevel})
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
 
-	// Send the HTTP request and read the response
-	resp, err := client.Do(req)
+// configureProxy routes all requests through the proxy at proxyURL. Without
+// it the default transport already honours HTTP_PROXY, HTTPS_PROXY and
+// NO_PROXY. The default transport is changed rather than a transport of our
+// own, because the Azure DevOps client creates its HTTP client internally.
+// HTTPS requests are tunnelled through the proxy with CONNECT, so TLS is
+// still verified end to end.
+func configureProxy(proxyURL string) error {
+	if proxyURL == "" {
+		return nil
+	}
+	u, err := url.Parse(proxyURL)
 	if err != nil {
-		return CapacityData{}, err
+		return fmt.Errorf("invalid proxyURL %q: %w", proxyURL, err)
 	}
-	defer resp.Body.Close()
-
-	// Check the response status code
-	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		return CapacityData{}, fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, string(bodyBytes))
+	transport, ok := http.DefaultTransport.(*http.Transport)
+	if !ok {
+		return errors.New("cannot set a proxy on the default HTTP transport")
 	}
+	transport.Proxy = http.ProxyURL(u)
+	return nil
+}
 
-	// Read the response body and unmarshal it into a CapacityData struct
-	var capacityData CapacityData
-	err = json.NewDecoder(resp.Body).Decode(&capacityData)
+// configureCACert adds the certificates of the PEM bundle at caCertFile to the
+// system roots trusted by the default transport, for Azure DevOps Server
+// behind a private CA. The connection's TlsConfig is left unset, since the
+// Azure DevOps client would then build a transport of its own without the
+// proxy and rate limit; it uses the default transport instead.
+func configureCACert(caCertFile string) error {
+	if caCertFile == "" {
+		return nil
+	}
+	pem, err := os.ReadFile(caCertFile)
+	if err != nil {
+		return err
+	}
+	roots, err := x509.SystemCertPool()
 	if err != nil {
-		return CapacityData{}, err
+		roots = x509.NewCertPool()
+	}
+	if !roots.AppendCertsFromPEM(pem) {
+		return fmt.Errorf("no PEM certificates found in %s", caCertFile)
+	}
+	transport, ok := http.DefaultTransport.(*http.Transport)
+	if !ok {
+		return errors.New("cannot set CA certificates on the default HTTP transport")
+	}
+	if transport.TLSClientConfig == nil {
+		transport.TLSClientConfig = &tls.Config{}
 	}
+	transport.TLSClientConfig.RootCAs = roots
+	return nil
+}
 
-	return capacityData, nil
+// configureTransport sizes the connection pool of the default transport for
+// concurrency parallel requests to Azure DevOps. Without it only two idle
+// connections per host are kept, so most parallel fetches would open a new
+// connection. It must be called before configureRateLimit.
+func configureTransport(concurrency int) error {
+	transport, ok := http.DefaultTransport.(*http.Transport)
+	if !ok {
+		return errors.New("cannot size the connection pool of the default HTTP transport")
+	}
+	transport.MaxIdleConnsPerHost = concurrency
+	transport.MaxConnsPerHost = concurrency
+	transport.IdleConnTimeout = 90 * time.Second
+	return nil
 }
 
-func fetchIterations(connection *azuredevops.Connection, project, team string) ([]work.TeamSettingsIteration, error) {
-	ctx := context.Background()
-	workClient, err := work.NewClient(ctx, connection)
-	if err != nil {
-		return nil, err
+// errTooManyErrors stops fetching once more sprints failed than the
+// maxErrors argument allows.
+var errTooManyErrors = errors.New("too many sprints failed")
+
+// errCapacityRejected stops fetching once Azure DevOps rejects a capacity
+// request with 401 or 403, since every other sprint would be rejected too.
+var errCapacityRejected = errors.New("capacity request rejected")
+
+// configureRateLimit limits all requests to Azure DevOps, including retries
+// and those of concurrent fetches, to perSecond requests per second. It wraps
+// the default transport, so it must be called after configureProxy,
+// configureCACert and configureTransport.
+func configureRateLimit(perSecond float64) {
+	http.DefaultTransport = capacity.NewRateLimitedTransport(http.DefaultTransport, perSecond)
+}
+
+// capacityResult is the outcome of fetching the capacity of one sprint.
+type capacityResult struct {
+	Sprint   sprintIteration
+	Capacity capacity.CapacityData
+	// Points are the completed points read from the work items, when
+	// fetchCapacities was given a fetchPoints function.
+	Points float64
+	Err    error
+}
+
+// fetchCapacities fetches the capacity of every sprint using at most
+// concurrency requests at a time. The results are in the order of sprints,
+// with per-sprint failures reported in their Err field. done is called with
+// the result of each sprint after it has been fetched. Once ctx is cancelled the remaining
+// sprints are not requested and get the context's error. When fetchPoints is
+// set, it is called for each sprint whose capacity was fetched and its
+// result stored in Points; an error fails the sprint.
+func fetchCapacities(ctx context.Context, connection *azuredevops.Connection, project string, sprints []sprintIteration, opts capacity.FetchOptions, concurrency int, fetchPoints func(context.Context, sprintIteration) (float64, error), done func(capacityResult)) []capacityResult {
+	results := make([]capacityResult, len(sprints))
+	indexes := make(chan int)
+
+	var wg sync.WaitGroup
+	for worker := 0; worker < concurrency; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				sprint := sprints[i]
+				if err := ctx.Err(); err != nil {
+					results[i] = capacityResult{Sprint: sprint, Err: err}
+					done(results[i])
+					continue
+				}
+				slog.Info("Working on sprint", "sprint", sprint.SprintNumber)
+				capacityData, err := capacity.FetchIterationCapacity(ctx, connection, project, sprint.Iteration.Id.String(), opts)
+				var points float64
+				if err == nil && fetchPoints != nil {
+					points, err = fetchPoints(ctx, sprint)
+				}
+				results[i] = capacityResult{Sprint: sprint, Capacity: capacityData, Points: points, Err: err}
+				done(results[i])
+			}
+		}()
 	}
 
-	// timeframe := string(work.TimeFrameValues.Current)
-	iterations, err := workClient.GetTeamIterations(ctx, work.GetTeamIterationsArgs{
-		Project:   &project,
-		Team:      &team,
-		Timeframe: nil, //&timeframe,
-	})
-	if err != nil {
-		return nil, err
+	for i := range sprints {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	return results
+}
+
+// fetchStatus returns the HTTP status of a failed capacity request, or 0 when
+// no response was received or the sprint failed otherwise, so failures can be
+// told apart in the structured logs.
+func fetchStatus(err error) int {
+	var fetchErr *capacity.CapacityFetchError
+	if errors.As(err, &fetchErr) {
+		return fetchErr.StatusCode
 	}
-	return *iterations, nil
+	return 0
+}
+
+// isRejected reports whether the capacity request failed because Azure
+// DevOps rejected the token, with 401 Unauthorized or 403 Forbidden.
+func isRejected(err error) bool {
+	status := fetchStatus(err)
+	return status == http.StatusUnauthorized || status == http.StatusForbidden
+}
+
+// teamCapacity returns the capacity per day and the days off of the team in
+// the sprint. The capacities of an iteration cover every team in it, so the
+// totals over all teams are only used when there is no entry for the team.
+func teamCapacity(data capacity.CapacityData, teamID, team string, sprintNumber int) (float64, int) {
+	if teamData, ok := data.Team(teamID); ok {
+		return teamData.TeamCapacityPerDay, teamData.TeamTotalDaysOff
+	}
+	slog.Warn("No capacity entry for the team, using the totals of the iteration", "team", team, "sprint", sprintNumber, "teams", len(data.Teams))
+	return data.TotalIterationCapacityPerDay, data.TotalIterationDaysOff
+}
+
+// sprintIteration is an iteration together with its parsed sprint number.
+type sprintIteration struct {
+	Iteration    work.TeamSettingsIteration
+	SprintNumber int
+	// Days is the number of working days in the sprint.
+	Days float64
+}
+
+// filterIterationsByTimeframe keeps the iterations in the time frame. An
+// empty time frame or TimeframeAll keeps every iteration.
+func filterIterationsByTimeframe(iterations []work.TeamSettingsIteration, timeframe string) []work.TeamSettingsIteration {
+	if timeframe == "" || timeframe == TimeframeAll {
+		return iterations
+	}
+	var kept []work.TeamSettingsIteration
+	for _, iteration := range iterations {
+		if iterationTimeframe(iteration) != timeframe {
+			slog.Debug("Skipping iteration outside the timeframe", "iteration", iterationName(iteration), "timeframe", iterationTimeframe(iteration))
+			continue
+		}
+		kept = append(kept, iteration)
+	}
+	return kept
+}
+
+// filterIterationsByPath keeps the iterations whose path starts with the
+// prefix, compared case-insensitively like Azure DevOps paths. An empty
+// prefix keeps every iteration.
+func filterIterationsByPath(iterations []work.TeamSettingsIteration, prefix string) []work.TeamSettingsIteration {
+	if prefix == "" {
+		return iterations
+	}
+	var kept []work.TeamSettingsIteration
+	for _, iteration := range iterations {
+		if iteration.Path == nil || !strings.HasPrefix(strings.ToLower(*iteration.Path), strings.ToLower(prefix)) {
+			slog.Debug("Skipping iteration outside the iteration path filter", "iteration", iterationName(iteration))
+			continue
+		}
+		kept = append(kept, iteration)
+	}
+	return kept
+}
+
+// filterIterations parses the sprint number of every iteration and keeps the
+// ones numbered from sprintStart through sprintEnd, where a zero sprintEnd
+// means no upper bound. Iterations whose name has no sprint number are logged
+// and skipped; their names are returned as well. Iterations without an ID,
+// which only malformed responses contain, cannot be fetched and are skipped.
+func filterIterations(iterations []work.TeamSettingsIteration, sprintPattern sprintNamePattern, sprintStart, sprintEnd int) ([]sprintIteration, []string) {
+	var sprints []sprintIteration
+	var unparseable []string
+	for _, iteration := range iterations {
+		if iteration.Id == nil {
+			slog.Warn("Skipping iteration without an ID", "iteration", iterationName(iteration))
+			continue
+		}
+		sprintNum, err := extractSprintNumber(iteration.Name, sprintPattern)
+		if err != nil {
+			slog.Warn("Error extracting sprint number from iteration name", "iteration", iterationName(iteration), "err", err)
+			unparseable = append(unparseable, iterationName(iteration))
+			continue
+		}
+
+		if sprintNum >= sprintStart && (sprintEnd == 0 || sprintNum <= sprintEnd) {
+			sprints = append(sprints, sprintIteration{Iteration: iteration, SprintNumber: sprintNum})
+		}
+	}
+	return sprints, unparseable
+}
+
+// dedupSprints drops repeated sprints, keeping the first. Since rows are keyed
+// on team and sprint number, a second iteration with the same sprint number
+// would otherwise overwrite the first one's row within the same run. A
+// repeated iteration ID is dropped silently; a different iteration with the
+// same sprint number is logged.
+func dedupSprints(sprints []sprintIteration) []sprintIteration {
+	seen := make(map[int]sprintIteration)
+	var unique []sprintIteration
+	for _, sprint := range sprints {
+		if first, ok := seen[sprint.SprintNumber]; ok {
+			if first.Iteration.Id == nil || sprint.Iteration.Id == nil || *first.Iteration.Id != *sprint.Iteration.Id {
+				slog.Warn("Skipping iteration with the same sprint number as an earlier one", "sprint", sprint.SprintNumber, "iteration", iterationName(sprint.Iteration), "kept", iterationName(first.Iteration))
+			}
+			continue
+		}
+		seen[sprint.SprintNumber] = sprint
+		unique = append(unique, sprint)
+	}
+	return unique
+}
+
+// stalePoints returns, in ascending order, the sprint numbers of the calculated points
+// entries that match no iteration, for example because the sprint was renamed
+// or removed after the entry was written.
+func stalePoints(pointsData []PointsCompleted, iterations []work.TeamSettingsIteration, sprintPattern sprintNamePattern) []int {
+	known := make(map[int]bool)
+	for _, iteration := range iterations {
+		if sprintNum, err := extractSprintNumber(iteration.Name, sprintPattern); err == nil {
+			known[sprintNum] = true
+		}
+	}
+	var stale []int
+	reported := make(map[int]bool)
+	for _, points := range pointsData {
+		if !points.Calculate || known[points.SprintNumber] || reported[points.SprintNumber] {
+			continue
+		}
+		reported[points.SprintNumber] = true
+		stale = append(stale, points.SprintNumber)
+	}
+	sort.Ints(stale)
+	return stale
 }
 
 type PointsCompleted struct {
-	SprintNumber int  `json:"sprint"`
-	Completed    int  `json:"completed"`
-	Calculate    bool `json:"calculate"`
+	SprintNumber int     `json:"sprint" yaml:"sprint"`
+	Completed    float64 `json:"completed" yaml:"completed"`
+	Calculate    bool    `json:"calculate" yaml:"calculate"`
+	// SkipForecast keeps the sprint, such as a hardening sprint, out of the
+	// average and leaves it without a forecast.
+	SkipForecast bool `json:"skipForecast" yaml:"skipForecast"`
+	// DaysInSprint overrides the daysInSprint argument for this sprint, for
+	// example when it was shortened by a holiday. Zero means no override.
+	DaysInSprint float64 `json:"daysInSprint" yaml:"daysInSprint"`
+	// Team limits the entry to one team of a -team-list-file run. Entries
+	// without a team apply to every team.
+	Team string `json:"team" yaml:"team"`
+}
+
+// pointsKey identifies the sprint of a team an entry of the points data is
+// for.
+type pointsKey struct {
+	team         string
+	sprintNumber int
+}
+
+// key returns the team and sprint the entry is for; team names compare
+// without regard to case, like in Azure DevOps.
+func (p PointsCompleted) key() pointsKey {
+	return pointsKey{team: strings.ToLower(p.Team), sprintNumber: p.SprintNumber}
+}
+
+// stdinPath is the file name that reads from standard input instead.
+const stdinPath = "-"
+
+// openInput opens the named file for reading, or standard input when the name
+// is stdinPath. Closing the returned reader leaves standard input open.
+func openInput(filename string) (io.ReadCloser, error) {
+	if filename == stdinPath {
+		return io.NopCloser(os.Stdin), nil
+	}
+	return os.Open(filename)
 }
 
 func readPointsCompletedFile(filename string) ([]PointsCompleted, error) {
-	file, err := os.Open(filename)
+	file, err := openInput(filename)
 	if err != nil {
 		return nil, err
 	}
 	defer file.Close()
 
 	var pointsData []PointsCompleted
-	err = json.NewDecoder(file).Decode(&pointsData)
+	err = decodeJSON(file, &pointsData)
 	if err != nil {
 		return nil, err
 	}
@@ -135,47 +492,323 @@ func readPointsCompletedFile(filename string) ([]PointsCompleted, error) {
 	return pointsData, nil
 }
 
-func findPointsCompleted(sprintNumber int, pointsData []PointsCompleted) int {
+// readPointsCompletedFiles reads a comma-separated list of points files and
+// merges them. An entry for a sprint replaces the entries for that sprint
+// from earlier files, with a warning when their values differ; duplicates
+// within one file are kept for checkDuplicatePoints to report.
+func readPointsCompletedFiles(filenames string) ([]PointsCompleted, error) {
+	var merged []PointsCompleted
+	source := make(map[pointsKey]string)
+	for _, filename := range strings.Split(filenames, ",") {
+		filename = strings.TrimSpace(filename)
+		pointsData, err := readPointsCompletedFile(filename)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", filename, err)
+		}
+
+		for _, points := range pointsData {
+			if earlier, ok := source[points.key()]; ok && earlier != filename {
+				kept := merged[:0]
+				for _, existing := range merged {
+					if existing.key() != points.key() {
+						kept = append(kept, existing)
+					} else if existing != points {
+						slog.Warn("Points file overrides a conflicting entry of an earlier file", "sprint", points.SprintNumber, "file", filename, "earlier", earlier)
+					}
+				}
+				merged = kept
+			}
+			source[points.key()] = filename
+			merged = append(merged, points)
+		}
+	}
+	return merged, nil
+}
+
+// findSkipForecast reports whether any entry of the sprint is marked to be
+// skipped by the forecast.
+func findSkipForecast(sprintNumber int, pointsData []PointsCompleted) bool {
+	for _, points := range pointsData {
+		if points.SkipForecast && points.SprintNumber == sprintNumber {
+			return true
+		}
+	}
+	return false
+}
+
+// findDaysInSprint returns the number of working days set for the sprint in
+// the points data, or zero when no entry overrides it.
+func findDaysInSprint(sprintNumber int, pointsData []PointsCompleted) float64 {
+	for _, points := range pointsData {
+		if points.DaysInSprint > 0 && points.SprintNumber == sprintNumber {
+			return points.DaysInSprint
+		}
+	}
+	return 0
+}
+
+// checkDuplicatePoints reports sprints that appear more than once in the
+// points data. findPointsCompleted only uses the first calculated entry, so
+// later entries, and in particular conflicting ones, would go unnoticed.
+func checkDuplicatePoints(pointsData []PointsCompleted) error {
+	entries := make(map[pointsKey][]PointsCompleted)
+	var order []pointsKey
+	for _, points := range pointsData {
+		if _, seen := entries[points.key()]; !seen {
+			order = append(order, points.key())
+		}
+		entries[points.key()] = append(entries[points.key()], points)
+	}
+
+	var errs []error
+	for _, key := range order {
+		duplicates := entries[key]
+		if len(duplicates) < 2 {
+			continue
+		}
+		conflicting := false
+		for _, points := range duplicates[1:] {
+			if points != duplicates[0] {
+				conflicting = true
+			}
+		}
+		sprint := fmt.Sprintf("sprint %d", key.sprintNumber)
+		if duplicates[0].Team != "" {
+			sprint = fmt.Sprintf("sprint %d of team %s", key.sprintNumber, duplicates[0].Team)
+		}
+		if conflicting {
+			errs = append(errs, fmt.Errorf("%s is listed %d times with conflicting values", sprint, len(duplicates)))
+		} else {
+			errs = append(errs, fmt.Errorf("%s is listed %d times", sprint, len(duplicates)))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// pointsNotFound marks a sprint without known completed points. Completed
+// points are never negative, so callers test for it with a < 0 comparison
+// rather than float equality.
+const pointsNotFound = -1.0
+
+func findPointsCompleted(sprintNumber int, pointsData []PointsCompleted) float64 {
 	for _, points := range pointsData {
 		if points.Calculate && points.SprintNumber == sprintNumber {
 			return points.Completed
 		}
 	}
-	return -1 // Sprint not found
+	return pointsNotFound // Sprint not found
 }
 
-func pointsCompletedDividedByTotalDaysAvailable(completed int, days_available int) float64 {
+type Args struct {
+	OrgURL                  string                `json:"orgURL" yaml:"orgURL"`
+	Token                   string                `json:"token" yaml:"token"`
+	Project                 string                `json:"project" yaml:"project"`
+	Team                    string                `json:"team" yaml:"team"`
+	SprintStart             int                   `json:"sprintStart" yaml:"sprintStart"`
+	SprintEnd               int                   `json:"sprintEnd" yaml:"sprintEnd"` // zero means no upper bound
+	DaysInSprint            float64               `json:"daysInSprint" yaml:"daysInSprint"`
+	MaxRetries              int                   `json:"maxRetries" yaml:"maxRetries"`
+	SprintNameRegex         string                `json:"sprintNameRegex" yaml:"sprintNameRegex"`
+	PIMultiplier            int                   `json:"piMultiplier" yaml:"piMultiplier"` // zero means a single sprint number group
+	RequestTimeout          string                `json:"requestTimeout" yaml:"requestTimeout"`
+	ForecastStrategy        string                `json:"forecastStrategy" yaml:"forecastStrategy"`
+	AvgWindow               int                   `json:"avgWindow" yaml:"avgWindow"`
+	DBPath                  string                `json:"dbPath" yaml:"dbPath"`
+	TableName               string                `json:"tableName" yaml:"tableName"`
+	DeriveDaysFromDates     bool                  `json:"deriveDaysFromDates" yaml:"deriveDaysFromDates"`
+	HolidaysFile            string                `json:"holidaysFile" yaml:"holidaysFile"`
+	AuthMode                string                `json:"authMode" yaml:"authMode"`
+	Concurrency             int                   `json:"concurrency" yaml:"concurrency"`
+	APIVersion              string                `json:"apiVersion" yaml:"apiVersion"`
+	IterationPathFilter     string                `json:"iterationPathFilter" yaml:"iterationPathFilter"`
+	ProxyURL                string                `json:"proxyURL" yaml:"proxyURL"`
+	CACertFile              string                `json:"caCertFile" yaml:"caCertFile"` // PEM bundle trusted next to the system roots
+	RoundingMode            capacity.RoundingMode `json:"roundingMode" yaml:"roundingMode"`
+	IncludeZeroPointSprints bool                  `json:"includeZeroPointSprints" yaml:"includeZeroPointSprints"`
+	ExcludeCurrentSprint    bool                  `json:"excludeCurrentSprint" yaml:"excludeCurrentSprint"`
+	Unit                    string                `json:"unit" yaml:"unit"`                             // label of what is counted, such as points or hours
+	MaxErrors               int                   `json:"maxErrors" yaml:"maxErrors"`                   // zero means no limit
+	RateLimit               float64               `json:"rateLimit" yaml:"rateLimit"`                   // requests per second, zero means no limit
+	RateLimitThreshold      float64               `json:"rateLimitThreshold" yaml:"rateLimitThreshold"` // zero never slows down
+	SubtractDaysOff         *bool                 `json:"subtractDaysOff" yaml:"subtractDaysOff"`       // default true
+	Timeframe               string                `json:"timeframe" yaml:"timeframe"`                   // past, current, future or all (default)
+	PointsSource            string                `json:"pointsSource" yaml:"pointsSource"`             // file (default) or workItems
+	CompletedStates         []string              `json:"completedStates" yaml:"completedStates"`       // work item states counted by workItems
+}
 
-	if days_available == 0 {
-		// Avoid divide-by-zero error
-		return 0.0
-	} else if completed == -1 {
-		// Sprint not calculated
-		return 0.5
-	} else {
-		return float64(completed) / float64(days_available)
+// Supported values of the pointsSource argument.
+const (
+	// PointsSourceFile reads the completed points from the points file.
+	PointsSourceFile = "file"
+	// PointsSourceWorkItems sums the story points of the completed work
+	// items of every iteration. Entries in the points file still override
+	// them and can still skip a sprint or change its length.
+	PointsSourceWorkItems = "workItems"
+)
+
+// pointsFileOptional reports whether a points file that could not be read
+// may be ignored: when the points come from the work items, a missing file
+// only means there are no overrides.
+func pointsFileOptional(args Args, err error) bool {
+	return args.PointsSource == PointsSourceWorkItems && errors.Is(err, os.ErrNotExist)
+}
+
+// subtractDaysOff reports whether the days off are subtracted from the days
+// available, which is the default.
+func subtractDaysOff(args Args) bool {
+	return args.SubtractDaysOff == nil || *args.SubtractDaysOff
+}
+
+// defaultUnit labels the completed and forecasted amounts when no unit is
+// configured.
+const defaultUnit = "points"
+
+// defaultAPIVersion is used when apiVersion is omitted from arguments.json.
+const defaultAPIVersion = "7.0"
+
+// apiVersionPattern matches Azure DevOps API versions such as 7.0, 7.1 or
+// 7.1-preview.1.
+var apiVersionPattern = regexp.MustCompile(`^\d+\.\d+(-preview(\.\d+)?)?$`)
+
+// defaultDBPath and defaultTableName are used when the arguments file and
+// flags do not name a database or table.
+const (
+	defaultDBPath    = "./data.sqlite"
+	defaultTableName = "iteration_capacity"
+)
+
+// tableNamePattern restricts table names to plain SQL identifiers, since the
+// name is spliced into the statements.
+var tableNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// resolveTableName returns the configured table name or the default.
+func resolveTableName(args Args) string {
+	if args.TableName == "" {
+		return defaultTableName
 	}
+	return args.TableName
 }
 
-type Args struct {
-	OrgURL       string  `json:"orgURL"`
-	Token        string  `json:"token"`
-	Project      string  `json:"project"`
-	Team         string  `json:"team"`
-	SprintStart  int     `json:"sprintStart"`
-	DaysInSprint float64 `json:"daysInSprint"`
+// quoteIdentifier quotes a validated table name for use in SQL statements.
+func quoteIdentifier(name string) string {
+	return `"` + name + `"`
+}
+
+// defaultMaxRetries is used when maxRetries is omitted from arguments.json.
+const defaultMaxRetries = 3
+
+// defaultConcurrency is used when concurrency is omitted from arguments.json.
+const defaultConcurrency = 4
+
+// defaultRequestTimeout is used when requestTimeout is omitted from arguments.json.
+const defaultRequestTimeout = 30 * time.Second
+
+// resolveRequestTimeout parses the requestTimeout duration (e.g. "45s"),
+// falling back to the default when it is empty.
+func resolveRequestTimeout(args Args) (time.Duration, error) {
+	if args.RequestTimeout == "" {
+		return defaultRequestTimeout, nil
+	}
+	timeout, err := time.ParseDuration(args.RequestTimeout)
+	if err != nil {
+		return 0, fmt.Errorf("invalid requestTimeout %q: %v", args.RequestTimeout, err)
+	}
+	if timeout <= 0 {
+		return 0, fmt.Errorf("requestTimeout must be positive, got %q", args.RequestTimeout)
+	}
+	return timeout, nil
+}
+
+// Config is the combined configuration file: the fields of the arguments
+// file together with the completed points of the points file.
+type Config struct {
+	Args            `yaml:",inline"`
+	PointsCompleted []PointsCompleted `json:"pointsCompleted" yaml:"pointsCompleted"`
+}
+
+// isYAMLFile reports whether the file name has a .yaml or .yml extension.
+func isYAMLFile(filename string) bool {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".yaml", ".yml":
+		return true
+	default:
+		return false
+	}
+}
+
+// decodeConfig decodes a configuration file into v: as YAML when the file
+// name has a YAML extension, and as JSON otherwise, including from stdin.
+// Unknown fields are rejected in both, so a misspelled key is reported
+// rather than ignored.
+func decodeConfig(filename string, r io.Reader, v any) error {
+	if isYAMLFile(filename) {
+		decoder := yaml.NewDecoder(r)
+		decoder.KnownFields(true)
+		return decoder.Decode(v)
+	}
+	return decodeJSON(r, v)
+}
+
+// decodeJSON decodes JSON into v, rejecting unknown fields. A value of the
+// wrong type is reported with the field name and the expected type.
+func decodeJSON(r io.Reader, v any) error {
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(v)
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		return fmt.Errorf("field %q must be %s, got a JSON %s", typeErr.Field, jsonTypeName(typeErr.Type), typeErr.Value)
+	}
+	return err
+}
+
+// jsonTypeName describes the JSON value that decodes into a Go type.
+func jsonTypeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "a whole number"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "true or false"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	case reflect.Struct, reflect.Map:
+		return "an object"
+	default:
+		return t.String()
+	}
+}
+
+func readConfigFile(filename string) (Config, error) {
+	file, err := openInput(filename)
+	if err != nil {
+		return Config{}, err
+	}
+	defer file.Close()
+
+	var config Config
+	err = decodeConfig(filename, file, &config)
+	if err != nil {
+		return Config{}, err
+	}
+
+	return config, nil
 }
 
 func readArgsFile(filename string) (Args, error) {
 	/* Important! Ignore this file in Git */
-	file, err := os.Open(filename)
+	file, err := openInput(filename)
 	if err != nil {
 		return Args{}, err
 	}
 	defer file.Close()
 
 	var args Args
-	err = json.NewDecoder(file).Decode(&args)
+	err = decodeConfig(filename, file, &args)
 	if err != nil {
 		return Args{}, err
 	}
@@ -183,224 +816,970 @@ func readArgsFile(filename string) (Args, error) {
 	return args, nil
 }
 
-func Forecast(daysAvailable float64, pointsCompleted float64, avgCompleted float64) int {
-	if pointsCompleted == 0.0 && daysAvailable > 0.0 {
-		return int(math.Round(daysAvailable * avgCompleted))
-	} else {
-		return 0
+// resolveToken returns the token (a PAT, or an access token in bearer mode)
+// from arguments.json, falling back to the AZURE_DEVOPS_PAT environment
+// variable when the Token field is empty.
+func resolveToken(args Args) (string, error) {
+	if args.Token != "" {
+		return args.Token, nil
 	}
+	if token := os.Getenv("AZURE_DEVOPS_PAT"); token != "" {
+		return token, nil
+	}
+	return "", fmt.Errorf("no personal access token found: set \"token\" in arguments.json or the AZURE_DEVOPS_PAT environment variable")
 }
 
+// validateArgs checks the arguments before any work is done and reports all
+// problems at once instead of stopping at the first.
+func validateArgs(args Args) error {
+	var problems []error
+
+	if args.OrgURL == "" {
+		problems = append(problems, errors.New("orgURL is required"))
+	} else if u, err := url.ParseRequestURI(args.OrgURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		problems = append(problems, fmt.Errorf("orgURL %q is not a valid http(s) URL", args.OrgURL))
+	}
+	if _, err := resolveToken(args); err != nil {
+		problems = append(problems, err)
+	}
+	if args.Project == "" {
+		problems = append(problems, errors.New("project is required"))
+	}
+	if args.Team == "" {
+		problems = append(problems, errors.New("team is required"))
+	}
+	if args.DeriveDaysFromDates {
+		if args.DaysInSprint < 0 {
+			problems = append(problems, fmt.Errorf("daysInSprint must not be negative, got %v", args.DaysInSprint))
+		}
+	} else if args.DaysInSprint <= 0 {
+		problems = append(problems, fmt.Errorf("daysInSprint must be positive, got %v", args.DaysInSprint))
+	}
+	if args.SprintStart < 0 {
+		problems = append(problems, fmt.Errorf("sprintStart must not be negative, got %d", args.SprintStart))
+	}
+	if args.SprintEnd < 0 {
+		problems = append(problems, fmt.Errorf("sprintEnd must not be negative, got %d", args.SprintEnd))
+	} else if args.SprintEnd != 0 && args.SprintEnd < args.SprintStart {
+		problems = append(problems, fmt.Errorf("sprintEnd (%d) must not be lower than sprintStart (%d)", args.SprintEnd, args.SprintStart))
+	}
+	if args.Concurrency < 0 {
+		problems = append(problems, fmt.Errorf("concurrency must not be negative, got %d", args.Concurrency))
+	}
+	if args.AvgWindow < 0 {
+		problems = append(problems, fmt.Errorf("avgWindow must not be negative, got %d", args.AvgWindow))
+	}
+	if err := capacity.ValidateForecastStrategy(args.ForecastStrategy); err != nil {
+		problems = append(problems, err)
+	}
+	if err := capacity.ValidateRoundingMode(args.RoundingMode); err != nil {
+		problems = append(problems, err)
+	}
+	if args.APIVersion != "" && !apiVersionPattern.MatchString(args.APIVersion) {
+		problems = append(problems, fmt.Errorf("apiVersion %q must look like 7.0 or 7.1-preview.1", args.APIVersion))
+	}
+	if args.AuthMode != "" && args.AuthMode != capacity.AuthModePAT && args.AuthMode != capacity.AuthModeBearer {
+		problems = append(problems, fmt.Errorf("authMode must be %q or %q, got %q", capacity.AuthModePAT, capacity.AuthModeBearer, args.AuthMode))
+	}
+	if args.ProxyURL != "" {
+		if u, err := url.Parse(args.ProxyURL); err != nil || (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "socks5") || u.Host == "" {
+			problems = append(problems, fmt.Errorf("proxyURL %q must be an http, https or socks5 URL", args.ProxyURL))
+		}
+	}
+	if args.PIMultiplier < 0 {
+		problems = append(problems, fmt.Errorf("piMultiplier must not be negative, got %d", args.PIMultiplier))
+	}
+	if args.MaxErrors < 0 {
+		problems = append(problems, fmt.Errorf("maxErrors must not be negative, got %d", args.MaxErrors))
+	}
+	if args.RateLimit < 0 {
+		problems = append(problems, fmt.Errorf("rateLimit must not be negative, got %v", args.RateLimit))
+	}
+	if args.RateLimitThreshold < 0 {
+		problems = append(problems, fmt.Errorf("rateLimitThreshold must not be negative, got %v", args.RateLimitThreshold))
+	}
+	switch work.TimeFrame(args.Timeframe) {
+	case "", TimeframeAll, work.TimeFrameValues.Past, work.TimeFrameValues.Current, work.TimeFrameValues.Future:
+	default:
+		problems = append(problems, fmt.Errorf("timeframe must be past, current, future or all, got %q", args.Timeframe))
+	}
+	if args.PointsSource != "" && args.PointsSource != PointsSourceFile && args.PointsSource != PointsSourceWorkItems {
+		problems = append(problems, fmt.Errorf("pointsSource must be %q or %q, got %q", PointsSourceFile, PointsSourceWorkItems, args.PointsSource))
+	}
+	if args.TableName != "" && !tableNamePattern.MatchString(args.TableName) {
+		problems = append(problems, fmt.Errorf("tableName %q must contain only letters, digits and underscores and not start with a digit", args.TableName))
+	}
+
+	return errors.Join(problems...)
+}
+
+// Exit statuses of the program, so scheduled runs can tell a degraded run
+// from a failed one.
+const (
+	exitOK = 0
+	// exitFailure means the run failed, including when no sprint could be
+	// fetched.
+	exitFailure = 1
+	// exitPartialFailure means some sprints could not be fetched; the others
+	// were processed and reported.
+	exitPartialFailure = 2
+	// exitNoMatchingIterations means no iteration passed the sprint filter,
+	// which usually means sprintStart or sprintEnd is wrong.
+	exitNoMatchingIterations = 3
+	// exitInterrupted means the run was stopped by SIGINT or SIGTERM after
+	// storing the sprints fetched so far.
+	exitInterrupted = 130
+)
+
 func main() {
 
-	pointsData, err := readPointsCompletedFile("points_completed.json")
-	if err != nil {
-		fmt.Println("Error reading points_completed.json:", err)
+	argsPath := flag.String("args", "arguments.json", "path to the arguments JSON file")
+	pointsPath := flag.String("points", "points_completed.json", "path to the points completed JSON file, or a comma-separated list of files merged in order")
+	strictPoints := flag.Bool("strict-points", false, "abort when a sprint is listed more than once in the points data")
+	configPath := flag.String("config", "", "path to a combined JSON file with the arguments and a pointsCompleted array; replaces -args and -points")
+	dbPath := flag.String("db", "", "path to the SQLite database file, or Postgres connection string (default dbPath from the arguments file, or ./data.sqlite)")
+	dbDriver := flag.String("db-driver", DriverSQLite, "database backend: sqlite3 or postgres")
+	csvPath := flag.String("csv", "", "also export the results as CSV to this path")
+	svgPath := flag.String("svg", "", "also write a chart of the completion ratio per sprint as SVG to this path")
+	outputDir := flag.String("output-dir", "", "also write iterations.csv, iterations.json and a copy of the SQLite database to a new timestamped folder in this directory")
+	htmlPath := flag.String("html", "", "also write the results as an HTML report to this path")
+	dryRun := flag.Bool("dry-run", false, "fetch and print the computed values without touching the database")
+	format := flag.String("format", "text", "report format: text or json")
+	outPath := flag.String("out", "", "write the report to this file instead of stdout")
+	cacheDir := flag.String("cache", "", "cache Azure DevOps responses in this directory and reuse them on later runs")
+	cacheGzip := flag.Bool("cache-gzip", false, "gzip the cached responses; they are stored as .json.gz and read back transparently")
+	refresh := flag.Bool("refresh", false, "ignore cached responses and overwrite them with fresh data")
+	sinceSprint := flag.Int("since-sprint", 0, "first sprint to process; overrides sprintStart in the arguments file")
+	untilSprint := flag.Int("until-sprint", 0, "last sprint to process, 0 for no upper bound; overrides sprintEnd in the arguments file")
+	serveAddr := flag.String("serve", "", "after the run, serve the results as JSON over HTTP on this address (e.g. :8080)")
+	logLevel := flag.String("log-level", "info", "log level: debug, info, warn or error")
+	verbose := flag.Bool("verbose", false, "log request URLs and status codes (same as -log-level debug)")
+	quiet := flag.Bool("quiet", false, "only log errors and do not print the report to stdout; the database and -out, -csv and -html files are still written")
+	appendMode := flag.Bool("append", false, "keep the existing database and update rows per team and sprint")
+	currentOnly := flag.Bool("current-only", false, "only fetch the current sprint and update its row, keeping the other rows (implies -append)")
+	fresh := flag.Bool("fresh", false, "drop and recreate the capacity table before the run (default unless -append is set)")
+	storeRaw := flag.Bool("store-raw", false, "store the raw capacity response of every sprint in the raw_capacity column for auditing")
+	failOnUnparseable := flag.Bool("fail-on-unparseable", false, "abort when an iteration name has no sprint number instead of skipping it")
+	printSchema := flag.Bool("print-schema", false, "print the database schema with a description of every column, then exit")
+	explain := flag.Bool("explain", false, "before the report, show how the completion ratio and forecast of every sprint were computed")
+	recompute := flag.Bool("recompute", false, "update the points, averages and forecasts of the stored rows from the points file without calling Azure DevOps")
+	teamListPath := flag.String("team-list-file", "", "process every team listed in this file, one per line with # comments, instead of the team in the arguments file")
+	comparePath := flag.String("compare", "", "after the report, compare the forecasts per sprint with those in this earlier SQLite database")
+	selfTest := flag.Bool("selftest", false, "check the config files, the connection to Azure DevOps, the token and the database, print a pass/fail summary, then exit")
+	validatePoints := flag.Bool("validate-points", false, "warn about calculated points entries whose sprint number matches no iteration of the team, then exit")
+	listIterations := flag.Bool("list", false, "list the team's iterations and their parsed sprint numbers, then exit")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [options]\n\n", os.Args[0])
+		fmt.Fprintln(flag.CommandLine.Output(), "Retrieves iteration capacities from Azure DevOps and forecasts team velocity.")
+		fmt.Fprintln(flag.CommandLine.Output(), "\nOptions:")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	// Exit through a deferred call, so the database and output file are
+	// closed before the status is returned
+	exitCode := exitOK
+	defer func() {
+		if exitCode != exitOK {
+			os.Exit(exitCode)
+		}
+	}()
+
+	if *verbose && *quiet {
+		fmt.Fprintln(os.Stderr, "Error: -verbose and -quiet cannot be combined")
+		os.Exit(1)
+	}
+	if *verbose {
+		*logLevel = "debug"
+	}
+	var stdout io.Writer = os.Stdout
+	if *quiet {
+		*logLevel = "error"
+		stdout = io.Discard
+	}
+	if err := setupLogger(*logLevel); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
 		os.Exit(1)
 	}
 
-	args, err := readArgsFile("arguments.json")
+	if *format != "text" && *format != "json" {
+		slog.Error("Unknown -format, expected text or json", "format", *format)
+		os.Exit(1)
+	}
+
+	if *printSchema {
+		store, err := newStore(*dbDriver, "", defaultTableName)
+		if err != nil {
+			slog.Error("Error in -db-driver", "err", err)
+			os.Exit(1)
+		}
+		printStoreSchema(stdout, store.Schema())
+		return
+	}
+
+	if *selfTest {
+		input := selfTestInput{
+			ConfigPath:   *configPath,
+			ArgsPath:     *argsPath,
+			PointsPath:   *pointsPath,
+			DBPath:       *dbPath,
+			DBDriver:     *dbDriver,
+			TeamListPath: *teamListPath,
+		}
+		if !runSelfTest(context.Background(), os.Stdout, input) {
+			exitCode = exitFailure
+		}
+		return
+	}
+
+	if *currentOnly {
+		*appendMode = true
+	}
+	if *appendMode && *fresh {
+		slog.Error("-append and -fresh cannot be combined")
+		os.Exit(1)
+	}
+
+	if *recompute && (*fresh || *dryRun || *currentOnly || *listIterations) {
+		slog.Error("-recompute cannot be combined with -fresh, -dry-run, -current-only or -list")
+		os.Exit(1)
+	}
+	if *validatePoints && (*recompute || *currentOnly || *listIterations) {
+		slog.Error("-validate-points cannot be combined with -recompute, -current-only or -list")
+		os.Exit(1)
+	}
+
+	if *configPath == "" && !*listIterations && *argsPath == stdinPath && *pointsPath == stdinPath {
+		slog.Error("Only one of -args and -points can be read from stdin; use -config to pipe both")
+		os.Exit(1)
+	}
+
+	var args Args
+	var pointsData []PointsCompleted
+	argsSource := *argsPath
+	if *configPath != "" {
+		config, err := readConfigFile(*configPath)
+		if err != nil {
+			slog.Error("Error reading config file", "path", *configPath, "err", err)
+			os.Exit(1)
+		}
+		args = config.Args
+		pointsData = config.PointsCompleted
+		argsSource = *configPath
+	} else {
+		var err error
+		args, err = readArgsFile(*argsPath)
+		if err != nil {
+			slog.Error("Error reading arguments file", "path", *argsPath, "err", err)
+			os.Exit(1)
+		}
+
+		// Listing iterations is used to set up the points file, so it need
+		// not exist yet
+		if !*listIterations {
+			pointsData, err = readPointsCompletedFiles(*pointsPath)
+			if err != nil && !pointsFileOptional(args, err) {
+				slog.Error("Error reading points file", "path", *pointsPath, "err", err)
+				os.Exit(1)
+			}
+		}
+	}
+
+	if err := checkDuplicatePoints(pointsData); err != nil {
+		if *strictPoints {
+			slog.Error("Duplicate sprints in points data", "err", err)
+			os.Exit(1)
+		}
+		slog.Warn("Duplicate sprints in points data; the first calculated entry is used", "err", err)
+	}
+
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "since-sprint":
+			args.SprintStart = *sinceSprint
+		case "until-sprint":
+			args.SprintEnd = *untilSprint
+		}
+	})
+
+	// Recomputing re-reads the points from the file, which would drop the
+	// points read from the work items
+	if *recompute && args.PointsSource == PointsSourceWorkItems {
+		slog.Error("-recompute cannot be used with pointsSource workItems; run again to refresh the points")
+		os.Exit(1)
+	}
+
+	teams := []string{args.Team}
+	if *teamListPath != "" {
+		var err error
+		teams, err = readTeamListFile(*teamListPath)
+		if err != nil {
+			slog.Error("Error reading team list file", "path", *teamListPath, "err", err)
+			os.Exit(1)
+		}
+		// The list replaces the team of the arguments file, which may be
+		// left out
+		args.Team = teams[0]
+	}
+
+	if err := validateArgs(args); err != nil {
+		slog.Error("Invalid arguments file", "path", argsSource, "err", err)
+		os.Exit(1)
+	}
+
+	token, err := resolveToken(args)
 	if err != nil {
-		fmt.Println("Error reading arguments.json:", err)
+		slog.Error("Error resolving token", "err", err)
 		os.Exit(1)
 	}
 
 	orgURL := args.OrgURL
-	token := args.Token
 	project := args.Project
-	team := args.Team
+	unit := args.Unit
+	if unit == "" {
+		unit = defaultUnit
+	}
 	sprintStart := args.SprintStart
 	daysInSprint := args.DaysInSprint
+	maxRetries := args.MaxRetries
+	if maxRetries == 0 {
+		maxRetries = defaultMaxRetries
+	}
 
-	// remove existing database file */
-	if _, err := os.Stat("./data.sqlite"); os.IsNotExist(err) {
-		// File does not exist
-	} else {
-		// File exists, try to remove it
-		if err := os.Remove("./data.sqlite"); err != nil {
-			fmt.Println("Error removing database file:", err)
-			return
-		}
+	concurrency := args.Concurrency
+	if concurrency == 0 {
+		concurrency = defaultConcurrency
 	}
 
-	// Open a new database file - Important! Ignore file in Git */
-	db, err := sql.Open("sqlite3", "./data.sqlite")
+	requestTimeout, err := resolveRequestTimeout(args)
 	if err != nil {
-		fmt.Println("Error opening database:", err)
-		return
+		slog.Error("Error in requestTimeout", "err", err)
+		os.Exit(1)
 	}
-	defer db.Close()
-
-	// Create a new table to store iteration capacities
-	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS iteration_capacity (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		name TEXT,
-		sprint_number INTEGER,
-		days_available REAL,
-		capacity_per_day REAL,
-		days_off INTEGER,
-		points_completed INTEGER,
-		pnts_complete_for_totaldays REAL,
-		avg_pnts_complete REAL,
-		forecasted_completed INTEGER
-	)`)
+
+	sprintPattern, err := compileSprintRegex(args.SprintNameRegex, args.PIMultiplier)
 	if err != nil {
-		fmt.Println("Error creating table:", err)
+		slog.Error("Error in sprintNameRegex", "err", err)
+		os.Exit(1)
+	}
+
+	var holidays []time.Time
+	if args.HolidaysFile != "" {
+		holidays, err = readHolidaysFile(args.HolidaysFile)
+		if err != nil {
+			slog.Error("Error reading holidays file", "path", args.HolidaysFile, "err", err)
+			os.Exit(1)
+		}
+	}
+
+	databasePath := *dbPath
+	if databasePath == "" {
+		databasePath = args.DBPath
+	}
+	if databasePath == "" && *dbDriver == DriverSQLite {
+		databasePath = defaultDBPath
+	}
+	if databasePath == "" {
+		slog.Error("A connection string is required for the database driver; set -db or dbPath", "driver", *dbDriver)
+		os.Exit(1)
+	}
+	table := resolveTableName(args)
+
+	store, err := newStore(*dbDriver, databasePath, table)
+	if err != nil {
+		slog.Error("Error in -db-driver", "err", err)
+		os.Exit(1)
+	}
+	runStarted := time.Now().UTC()
+	runTimestamp := runStarted.Format(time.RFC3339)
+
+	// Read the forecasts to compare with before the database is opened, so
+	// comparing with the database that is about to be rebuilt works too
+	var previousForecasts map[sprintKey]*int64
+	if *comparePath != "" {
+		previousForecasts, err = readForecasts(*comparePath, table)
+		if err != nil {
+			slog.Error("Error reading the database to compare with", "path", *comparePath, "err", err)
+			os.Exit(1)
+		}
+	}
+
+	report := reportOptions{
+		Format:            *format,
+		OutPath:           *outPath,
+		CSVPath:           *csvPath,
+		HTMLPath:          *htmlPath,
+		SVGPath:           *svgPath,
+		OutputDir:         *outputDir,
+		RunStarted:        runStarted,
+		DBDriver:          *dbDriver,
+		DatabasePath:      databasePath,
+		ServeAddr:         *serveAddr,
+		PreviousForecasts: previousForecasts,
+		Baseline:          baselineFromArgs(args),
+	}
+
+	// Recomputing only needs the capacities stored by an earlier run
+	if *recompute {
+		if err := store.Init(false); err != nil {
+			slog.Error("Error opening database", "err", err)
+			os.Exit(1)
+		}
+		defer store.Close()
+		for _, team := range teams {
+			days := func(row IterationCapacityRow) (float64, error) {
+				return storedSprintDays(row, daysInSprint, args.DeriveDaysFromDates, holidays)
+			}
+			if err := recomputePoints(store, team, pointsForTeam(pointsData, team), days); err != nil {
+				slog.Error("Error recomputing points", "team", team, "err", err)
+				exitCode = exitFailure
+				return
+			}
+			if err := updateForecasts(store, team, args); err != nil {
+				slog.Error("Error updating forecasts", "team", team, "err", err)
+				exitCode = exitFailure
+				return
+			}
+		}
+		if *explain {
+			for _, team := range teams {
+				if err := explainForecasts(stdout, store, team, args); err != nil {
+					slog.Error("Error explaining forecasts", "err", err)
+					exitCode = exitFailure
+					return
+				}
+			}
+		}
+		if err := writeReports(store, stdout, report); err != nil {
+			slog.Error("Error reporting", "err", err)
+			exitCode = exitFailure
+		}
 		return
 	}
 
-	connection := azuredevops.NewPatConnection(orgURL, token)
-	iterations, err := fetchIterations(connection, project, team)
+	cache, err := capacity.NewResponseCache(*cacheDir, *refresh, *cacheGzip)
 	if err != nil {
-		fmt.Println("Error fetching iterations:", err)
+		slog.Error("Error creating cache directory", "err", err)
 		os.Exit(1)
 	}
+	apiVersion := args.APIVersion
+	if apiVersion == "" {
+		apiVersion = defaultAPIVersion
+	}
 
-	for _, iteration := range iterations {
+	opts := capacity.FetchOptions{
+		MaxRetries:         maxRetries,
+		Timeout:            requestTimeout,
+		Cache:              cache,
+		APIVersion:         apiVersion,
+		RateLimitThreshold: args.RateLimitThreshold,
+		// One client for all requests; it uses the default transport,
+		// configured below, like the Azure DevOps client does
+		HTTPClient: &http.Client{Timeout: requestTimeout},
+	}
+
+	if err := configureProxy(args.ProxyURL); err != nil {
+		slog.Error("Error configuring proxy", "err", err)
+		os.Exit(1)
+	}
+	if err := configureCACert(args.CACertFile); err != nil {
+		slog.Error("Error loading CA certificate file", "path", args.CACertFile, "err", err)
+		os.Exit(1)
+	}
+	if err := configureTransport(concurrency); err != nil {
+		slog.Error("Error configuring connections", "err", err)
+		os.Exit(1)
+	}
+	configureRateLimit(args.RateLimit)
+
+	// Ctrl-C or SIGTERM stops fetching; what was fetched is still stored
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	connection := capacity.NewConnection(orgURL, token, args.AuthMode)
+	connection.Timeout = &requestTimeout
+	workClient := &capacity.ConnectionWorkClient{Connection: connection}
+
+	// Determine the sprints of every team before the database is opened, so
+	// a run that stops on its iterations leaves an existing database
+	// untouched
+	var plans []teamSprints
+	for _, team := range teams {
+		teamPoints := pointsForTeam(pointsData, team)
+
+		// Without a cache every run needs the token, so check it before the
+		// database is opened; cached offline runs may not need it at all
+		if cache == nil || *refresh {
+			if err := capacity.CheckToken(ctx, connection, project, team, opts); err != nil {
+				slog.Error("Error checking token", "team", team, "err", err)
+				os.Exit(1)
+			}
+		}
 
-		sprintNum, err := extractSprintNumber(iteration.Name)
+		timeframe := args.Timeframe
+		if *currentOnly {
+			timeframe = TimeframeCurrent
+		}
+		// The iterations endpoint only filters on the current time frame;
+		// past and future iterations are filtered after fetching them all
+		var apiTimeframe string
+		if timeframe == TimeframeCurrent {
+			apiTimeframe = timeframe
+		}
+		iterations, err := capacity.FetchIterations(ctx, workClient, project, team, apiTimeframe, opts)
 		if err != nil {
-			fmt.Printf("Error extracting sprint number from iteration name '%s': %v\n", *iteration.Name, err)
+			slog.Error("Error fetching iterations", "team", team, "err", err)
+			os.Exit(1)
+		}
+
+		// Points entries may be for any sprint of the team, so they are
+		// checked against its iterations of every time frame
+		if *validatePoints {
+			stale := stalePoints(teamPoints, filterIterationsByPath(iterations, args.IterationPathFilter), sprintPattern)
+			for _, sprintNumber := range stale {
+				slog.Warn("Calculated points entry matches no iteration; the sprint may have been renamed or removed", "team", team, "sprint", sprintNumber)
+			}
+			if len(stale) > 0 {
+				exitCode = exitFailure
+			}
+			fmt.Fprintf(stdout, "%s: %d calculated points entries without an iteration\n", team, len(stale))
 			continue
 		}
 
-		if sprintNum >= sprintStart {
+		iterations = filterIterationsByTimeframe(iterations, timeframe)
+		iterations = filterIterationsByPath(iterations, args.IterationPathFilter)
+
+		if *listIterations {
+			if len(teams) > 1 {
+				fmt.Fprintf(os.Stdout, "Team: %s\n", team)
+			}
+			printIterations(os.Stdout, iterations, sprintPattern)
+			if len(teams) > 1 {
+				fmt.Fprintln(os.Stdout)
+			}
+			continue
+		}
 
-			fmt.Printf("Working on sprint: %d\n", sprintNum)
+		sprints, unparseable := filterIterations(iterations, sprintPattern, sprintStart, args.SprintEnd)
+		if *failOnUnparseable && len(unparseable) > 0 {
+			slog.Error("Iteration names without a sprint number; check sprintNameRegex", "team", team, "iterations", unparseable)
+			os.Exit(1)
+		}
+		sprints = dedupSprints(sprints)
+		if len(sprints) == 0 {
+			if args.SprintEnd != 0 {
+				slog.Warn(fmt.Sprintf("No iterations matched sprint >= %d and <= %d", sprintStart, args.SprintEnd), "team", team, "iterations", len(iterations))
+			} else {
+				slog.Warn(fmt.Sprintf("No iterations matched sprint >= %d", sprintStart), "team", team, "iterations", len(iterations))
+			}
+			continue
+		}
 
-			// Fetch iteration capacity details
-			capacityData, err := fetchIterationCapacity(connection, token, project, iteration.Id.String())
+		// Determine the length of each sprint before fetching, so iterations
+		// without a usable date range do not cost a request
+		var ready []sprintIteration
+		for _, sprint := range sprints {
+			// A per-sprint override wins over the fixed and the derived length
+			if days := findDaysInSprint(sprint.SprintNumber, teamPoints); days > 0 {
+				sprint.Days = days
+				ready = append(ready, sprint)
+				continue
+			}
+			days, err := sprintDays(sprint.Iteration, daysInSprint, args.DeriveDaysFromDates, holidays)
 			if err != nil {
-				fmt.Printf("Error fetching capacities for iteration '%s': %v\n", *iteration.Name, err)
+				slog.Warn("Skipping iteration without a valid date range", "team", team, "iteration", *sprint.Iteration.Name, "err", err)
 				continue
 			}
-
-			daysAvailable := (capacityData.TotalIterationCapacityPerDay * daysInSprint) - float64(capacityData.TotalIterationDaysOff)
-			pointsCompleted := findPointsCompleted(sprintNum, pointsData)
-			pointsCompletedForTotalDays := pointsCompletedDividedByTotalDaysAvailable(int(pointsCompleted), int(daysAvailable))
-
-			// Insert a new row into the table
-			_, err = db.Exec(`INSERT INTO iteration_capacity (
-				name, sprint_number, days_available, capacity_per_day, days_off, points_completed, pnts_complete_for_totaldays
-				) VALUES (?, ?, ?, ?, ?, ?, ?)`,
-				iteration.Name,
-				sprintNum,
-				daysAvailable,
-				capacityData.TotalIterationCapacityPerDay,
-				capacityData.TotalIterationDaysOff,
-				pointsCompleted,
-				pointsCompletedForTotalDays)
+			sprint.Days = days
+			ready = append(ready, sprint)
+		}
+		teamID, err := capacity.FetchTeamID(ctx, connection, project, team, opts)
+		if err != nil {
+			slog.Error("Error looking up team", "team", team, "err", err)
+			os.Exit(1)
+		}
+		var teamValues capacity.TeamFieldValues
+		if args.PointsSource == PointsSourceWorkItems {
+			teamValues, err = capacity.FetchTeamFieldValues(ctx, connection, project, team, opts)
 			if err != nil {
-				fmt.Println("Error inserting row:", err)
+				slog.Error("Error fetching the area paths of the team", "team", team, "err", err)
+				os.Exit(1)
+			}
+		}
+		plans = append(plans, teamSprints{Team: team, TeamID: teamID, TeamValues: teamValues, Points: teamPoints, Sprints: ready})
+	}
+
+	if *listIterations || *validatePoints {
+		return
+	}
+	// A team list may include teams without matching sprints, as long as
+	// one team has them
+	if len(plans) == 0 {
+		os.Exit(exitNoMatchingIterations)
+	}
+
+	if !*dryRun {
+		if err := store.Init(!*appendMode); err != nil {
+			slog.Error("Error opening database", "err", err)
+			exitCode = exitFailure
+			return
+		}
+		defer store.Close()
+	}
+
+	calculator := capacity.Calculator{KeepDaysOff: !subtractDaysOff(args)}
+
+	var processed []string
+	failed, interrupted, fetched := 0, 0, 0
+	for _, planned := range plans {
+		// Teams not started before a signal are left as they are
+		if ctx.Err() != nil {
+			break
+		}
+		team := planned.Team
+		processed = append(processed, team)
+
+		bar := newProgress(len(planned.Sprints))
+		if *quiet {
+			bar.enabled = false
+		}
+		// Past maxErrors failures the remaining sprints are not fetched
+		fetchCtx, cancelFetch := context.WithCancelCause(ctx)
+		var fetchErrors atomic.Int32
+		var fetchPoints func(context.Context, sprintIteration) (float64, error)
+		if args.PointsSource == PointsSourceWorkItems {
+			fetchPoints = func(ctx context.Context, sprint sprintIteration) (float64, error) {
+				if iterationPath(sprint.Iteration) == "" {
+					return 0, errors.New("fetching completed points: the iteration has no path")
+				}
+				points, err := capacity.FetchCompletedPoints(ctx, connection, project, team, planned.TeamValues, sprint.Iteration.Id.String(), iterationPath(sprint.Iteration), args.CompletedStates, opts)
+				if err != nil {
+					return 0, fmt.Errorf("fetching completed points: %w", err)
+				}
+				return points, nil
+			}
+		}
+		results := fetchCapacities(fetchCtx, connection, project, planned.Sprints, opts, concurrency, fetchPoints, func(result capacityResult) {
+			bar.Increment()
+			if isRejected(result.Err) {
+				cancelFetch(errCapacityRejected)
+			} else if result.Err != nil && args.MaxErrors > 0 && int(fetchErrors.Add(1)) > args.MaxErrors {
+				cancelFetch(errTooManyErrors)
+			}
+		})
+		bar.Finish()
+
+		if cause := context.Cause(fetchCtx); errors.Is(cause, errTooManyErrors) || errors.Is(cause, errCapacityRejected) {
+			var failedSprints []int
+			for _, result := range results {
+				if result.Err != nil && !errors.Is(result.Err, context.Canceled) {
+					slog.Error("Error fetching capacities for iteration", "team", team, "iteration", *result.Sprint.Iteration.Name, "status", fetchStatus(result.Err), "err", result.Err)
+					failedSprints = append(failedSprints, result.Sprint.SprintNumber)
+				}
+			}
+			if errors.Is(cause, errCapacityRejected) {
+				slog.Error("Aborting without storing the team's sprints: Azure DevOps rejected the token", "team", team, "failedSprints", failedSprints)
+			} else {
+				slog.Error("Aborting without storing the team's sprints: more sprints failed than maxErrors allows", "team", team, "maxErrors", args.MaxErrors, "failedSprints", failedSprints)
+			}
+			exitCode = exitFailure
+			cancelFetch(nil)
+			// The sprints of the teams before this one are stored, so
+			// their averages and forecasts are brought up to date
+			if !*dryRun {
+				for _, earlier := range processed[:len(processed)-1] {
+					if err := updateForecasts(store, earlier, args); err != nil {
+						slog.Error("Error updating forecasts", "team", earlier, "err", err)
+					}
+				}
+			}
+			return
+		}
+		cancelFetch(nil)
+		fetched += len(results)
+
+		var records []SprintRecord
+		for _, result := range results {
+			iteration := result.Sprint.Iteration
+			sprintNum := result.Sprint.SprintNumber
+			days := result.Sprint.Days
+			capacityData := result.Capacity
+			timeframe := iterationTimeframe(iteration)
+
+			if result.Err != nil && ctx.Err() != nil && errors.Is(result.Err, ctx.Err()) {
+				interrupted++
+				continue
+			}
+			if result.Err != nil {
+				slog.Error("Error fetching capacities for iteration", "team", team, "iteration", *iteration.Name, "status", fetchStatus(result.Err), "err", result.Err)
+				failed++
+				continue
+			}
+
+			capacityPerDay, daysOff := teamCapacity(capacityData, planned.TeamID, team, sprintNum)
+			daysAvailable := calculator.DaysAvailable(capacityPerDay, days, daysOff)
+			var daysOffRatio *float64
+			if ratio, ok := calculator.DaysOffRatio(capacityPerDay, days, daysOff); ok {
+				daysOffRatio = &ratio
+			}
+			pointsCompleted := findPointsCompleted(sprintNum, planned.Points)
+			if args.PointsSource == PointsSourceWorkItems && pointsCompleted < 0 {
+				pointsCompleted = result.Points
+			}
+			pointsCompletedForTotalDays, ratioStatus := calculator.CompletionRatio(pointsCompleted, daysAvailable)
+
+			if *dryRun {
+				if len(teams) > 1 {
+					fmt.Fprintf(stdout, "Team: %s\n", team)
+				}
+				fmt.Fprintf(stdout, "Name: %s\n", *iteration.Name)
+				fmt.Fprintf(stdout, "Timeframe: %s\n", timeframe)
+				fmt.Fprintf(stdout, "Days Available: %f\n", daysAvailable)
+				fmt.Fprintf(stdout, "Capacity Per Day: %f\n", capacityPerDay)
+				fmt.Fprintf(stdout, "Days Off: %d\n", daysOff)
+				fmt.Fprintf(stdout, "%s Completed: %g\n", unitLabel(unit), pointsCompleted)
+				fmt.Fprintf(stdout, "%s Completed vs Days Available: %f (%s)\n", unitLabel(unit), pointsCompletedForTotalDays, ratioStatus)
+				fmt.Fprintln(stdout)
+				continue
+			}
+
+			startDate, endDate := iterationDates(iteration)
+
+			var rawCapacity string
+			if *storeRaw {
+				rawCapacity = string(capacityData.Raw)
+			}
+
+			records = append(records, SprintRecord{Row: IterationCapacityRow{
+				Team:                        team,
+				Name:                        *iteration.Name,
+				SprintNumber:                sprintNum,
+				DaysAvailable:               daysAvailable,
+				CapacityPerDay:              capacityPerDay,
+				DaysOff:                     daysOff,
+				DaysOffRatio:                daysOffRatio,
+				DaysOffSubtracted:           !calculator.KeepDaysOff,
+				StartDate:                   startDate,
+				EndDate:                     endDate,
+				PointsCompleted:             pointsCompleted,
+				PointsCompletedForTotalDays: pointsCompletedForTotalDays,
+				RatioStatus:                 ratioStatus,
+				RunTimestamp:                runTimestamp,
+				Timeframe:                   timeframe,
+				SkipForecast:                findSkipForecast(sprintNum, planned.Points),
+				RawCapacity:                 rawCapacity,
+				Unit:                        unit,
+			}, Teams: capacityData.Teams})
+		}
+
+		// Insert new rows, or update the rows of an earlier run, all at once
+		// so a failure leaves the team's rows as they were
+		if len(records) > 0 {
+			if err := store.InsertSprints(records, runTimestamp); err != nil {
+				slog.Error("Error inserting rows", "team", team, "err", err)
+				exitCode = exitFailure
 				return
 			}
 		}
 	}
 
-	fmt.Println("Determine the average of Completed vs Capacity!")
-	_, err = db.Exec(`UPDATE iteration_capacity 
-		SET avg_pnts_complete = (SELECT AVG(pnts_complete_for_totaldays) 
-		FROM iteration_capacity WHERE points_completed <> 0)`)
-	if err != nil {
-		fmt.Println("Error updating rows:", err)
+	// A second signal now ends the program at once
+	stop()
+
+	if failed > 0 && failed == fetched-interrupted {
+		slog.Error("Could not fetch the capacity of any sprint", "failed", failed)
+		exitCode = exitFailure
 		return
 	}
+	if failed > 0 {
+		slog.Warn("Could not fetch the capacity of some sprints", "failed", failed, "succeeded", fetched-failed-interrupted)
+		exitCode = exitPartialFailure
+	}
 
-	fmt.Println("Determine the Forecasted Completed!")
-	tx, err := db.Begin()
-	if err != nil {
-		fmt.Println("Error beginning transaction:", err)
+	skippedTeams := len(plans) - len(processed)
+	if interrupted > 0 || skippedTeams > 0 {
+		slog.Warn("Interrupted; stored the sprints fetched so far", "skipped", interrupted, "stored", fetched-interrupted-failed, "skippedTeams", skippedTeams)
+		exitCode = exitInterrupted
+	}
+
+	if *dryRun {
+		slog.Info("Dry run: no changes were written to the database")
 		return
 	}
-	defer tx.Rollback()
 
-	rowsY, err := tx.Query(`SELECT id, points_completed, avg_pnts_complete, days_available FROM iteration_capacity`)
-	if err != nil {
-		fmt.Println("Error selecting rows:", err)
+	for _, team := range processed {
+		if err := updateForecasts(store, team, args); err != nil {
+			slog.Error("Error updating forecasts", "team", team, "err", err)
+			exitCode = exitFailure
+			return
+		}
+	}
+
+	// The stored rows are consistent now; skip the reports of an interrupted run
+	if exitCode == exitInterrupted {
 		return
 	}
-	defer rowsY.Close()
 
-	for rowsY.Next() {
-		var id int
-		var points_completed int
-		var avg_pnts_complete float64
-		var days_available int
-		err := rowsY.Scan(&id, &points_completed, &avg_pnts_complete, &days_available)
-		if err != nil {
-			fmt.Println("Error scanning row:", err)
-			continue
+	if *explain {
+		for _, team := range processed {
+			if err := explainForecasts(stdout, store, team, args); err != nil {
+				slog.Error("Error explaining forecasts", "err", err)
+				exitCode = exitFailure
+				return
+			}
 		}
+	}
 
-		forecastedCompleted := Forecast(float64(days_available), float64(points_completed), float64(avg_pnts_complete))
-		fmt.Printf("id %d calculated: %d\n", id, forecastedCompleted)
+	if err := writeReports(store, stdout, report); err != nil {
+		slog.Error("Error reporting", "err", err)
+		exitCode = exitFailure
+	}
+}
 
-		_, err = tx.Exec(`UPDATE iteration_capacity 
-			SET forecasted_completed = ? 
-			WHERE id = ?`,
-			forecastedCompleted, id)
+// recomputePoints updates the completed points and completion ratios of the
+// team's stored rows from the points data, keeping the capacities that were
+// fetched earlier. The days available are recomputed from the stored
+// capacity with the length of each sprint: its daysInSprint override in the
+// points data, or else sprintDays, as in a normal run. A sprint whose length
+// cannot be determined keeps its stored days available.
+func recomputePoints(store CapacityStore, team string, pointsData []PointsCompleted, sprintDays func(IterationCapacityRow) (float64, error)) error {
+	rows, err := store.AllRows()
+	if err != nil {
+		return fmt.Errorf("selecting rows: %w", err)
+	}
 
+	updated := 0
+	for _, row := range rows {
+		if row.Team != team {
+			continue
+		}
+		calculator := capacity.Calculator{KeepDaysOff: !row.DaysOffSubtracted}
+		days := findDaysInSprint(row.SprintNumber, pointsData)
+		var err error
+		if days <= 0 {
+			days, err = sprintDays(row)
+		}
 		if err != nil {
-			fmt.Println("Error updating rows:", err)
-			return
+			slog.Warn("Keeping the stored days available of a sprint without a valid date range", "team", team, "sprint", row.SprintNumber, "err", err)
+		} else {
+			row.DaysAvailable = calculator.DaysAvailable(row.CapacityPerDay, days, row.DaysOff)
+			row.DaysOffRatio = nil
+			if ratio, ok := calculator.DaysOffRatio(row.CapacityPerDay, days, row.DaysOff); ok {
+				row.DaysOffRatio = &ratio
+			}
 		}
+		row.PointsCompleted = findPointsCompleted(row.SprintNumber, pointsData)
+		row.PointsCompletedForTotalDays, row.RatioStatus = calculator.CompletionRatio(row.PointsCompleted, row.DaysAvailable)
+		row.SkipForecast = findSkipForecast(row.SprintNumber, pointsData)
+		if err := store.UpdatePoints(row); err != nil {
+			return fmt.Errorf("updating sprint %d: %w", row.SprintNumber, err)
+		}
+		updated++
 	}
-
-	err = tx.Commit()
-	if err != nil {
-		fmt.Println("Error committing transaction:", err)
-		return
+	if updated == 0 {
+		slog.Warn("No stored rows for the team; run without -recompute first", "team", team)
 	}
+	slog.Info("Recomputed points", "team", team, "rows", updated)
+	return nil
+}
+
+// reportOptions selects where writeReports reports the rows.
+type reportOptions struct {
+	// Format is the report format: text or json.
+	Format string
+	// OutPath is the file the report is written to instead of stdout.
+	OutPath string
+	// CSVPath, HTMLPath and SVGPath are the optional exports.
+	CSVPath  string
+	HTMLPath string
+	SVGPath  string
+	// OutputDir is the directory of the run archive, with RunStarted naming
+	// its folder. The database at DatabasePath is copied for SQLite.
+	OutputDir    string
+	RunStarted   time.Time
+	DBDriver     string
+	DatabasePath string
+	// ServeAddr is the address the rows are served on after reporting.
+	ServeAddr string
+	// PreviousForecasts are the forecasts of the -compare database; the
+	// forecasts of the rows are compared to them after the report.
+	PreviousForecasts map[sprintKey]*int64
+	// Baseline selects the sprints whose completion ratios are charted,
+	// counted in the histogram and summed into the overall efficiency.
+	Baseline Baseline
+}
 
-	// Select all rows from the table and print them
-	rowsX, err := db.Query("SELECT * FROM iteration_capacity")
+// writeReports selects all rows from the store and writes the report and the
+// exports of opts. When a serve address is set it serves the rows until the
+// server fails.
+func writeReports(store CapacityStore, stdout io.Writer, opts reportOptions) error {
+	rows, err := store.AllRows()
 	if err != nil {
-		fmt.Println("Error selecting rows:", err)
-		return
+		return fmt.Errorf("selecting rows: %w", err)
 	}
-	defer rowsX.Close()
-
-	var id int
-	var name string
-	var sprint_number int
-	var days_available float64
-	var capacity_per_day float64
-	var days_off int
-	var points_completed int
-	var pnts_complete_for_totaldays float64
-	var avg_pnts_complete float64
-	var forecasted_completed sql.NullInt64
-
-	for rowsX.Next() {
-		err := rowsX.Scan(
-			&id,
-			&name,
-			&sprint_number,
-			&days_available,
-			&capacity_per_day,
-			&days_off,
-			&points_completed,
-			&pnts_complete_for_totaldays,
-			&avg_pnts_complete,
-			&forecasted_completed)
+
+	var out io.Writer = stdout
+	if opts.OutPath != "" {
+		file, err := os.Create(opts.OutPath)
 		if err != nil {
-			fmt.Println("Error scanning row:", err)
-			return
+			return fmt.Errorf("creating output file: %w", err)
+		}
+		defer file.Close()
+		out = file
+	}
+
+	switch opts.Format {
+	case "json":
+		if err := writeJSON(out, rows); err != nil {
+			return fmt.Errorf("writing JSON: %w", err)
+		}
+	default:
+		printRows(out, rows, opts.Baseline)
+	}
+
+	if opts.PreviousForecasts != nil {
+		compareForecasts(stdout, opts.PreviousForecasts, rows)
+	}
+
+	if opts.CSVPath != "" {
+		if err := exportCSV(rows, opts.CSVPath); err != nil {
+			return fmt.Errorf("exporting CSV: %w", err)
+		}
+		slog.Info("Exported CSV", "path", opts.CSVPath)
+	}
+
+	if opts.HTMLPath != "" {
+		if err := exportHTML(rows, opts.HTMLPath); err != nil {
+			return fmt.Errorf("writing HTML report: %w", err)
+		}
+		slog.Info("Wrote HTML report", "path", opts.HTMLPath)
+	}
+
+	if opts.SVGPath != "" {
+		if err := exportSVG(rows, opts.Baseline, opts.SVGPath); err != nil {
+			return fmt.Errorf("writing SVG chart: %w", err)
 		}
-		fmt.Printf("ID: %d\n", id)
-		fmt.Printf("Sprint: %d\n", sprint_number)
-		fmt.Printf("Name: %s\n", name)
-		fmt.Printf("Days Available: %f\n", days_available)
-		fmt.Printf("Capacity Per Day: %f\n", capacity_per_day)
-		fmt.Printf("Days Off: %d\n", days_off)
-		fmt.Printf("Points Completed: %d\n", points_completed)
-		fmt.Printf("Points Completed vs Days Available: %f\n", pnts_complete_for_totaldays)
-		fmt.Printf("Avg Completed vs Capacity: %f\n", avg_pnts_complete)
-		if forecasted_completed.Valid {
-			fmt.Printf("Forcasted: %d\n", forecasted_completed.Int64)
+		slog.Info("Wrote SVG chart", "path", opts.SVGPath)
+	}
+
+	if opts.OutputDir != "" {
+		// Only a SQLite database is a file that can be archived
+		sqlitePath := ""
+		if opts.DBDriver == DriverSQLite {
+			sqlitePath = opts.DatabasePath
 		} else {
-			fmt.Println("Forcasted: NULL")
+			slog.Warn("The database is not copied to -output-dir for this driver", "driver", opts.DBDriver)
+		}
+		runDir, err := exportRunDir(opts.OutputDir, opts.RunStarted, rows, sqlitePath)
+		if err != nil {
+			return fmt.Errorf("writing to output directory: %w", err)
+		}
+		slog.Info("Wrote run to output directory", "path", runDir)
+	}
+
+	if opts.ServeAddr != "" {
+		if err := serveRows(opts.ServeAddr, rows); err != nil {
+			return fmt.Errorf("serving iterations: %w", err)
 		}
-		fmt.Println()
 	}
+	return nil
 }
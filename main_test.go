@@ -0,0 +1,288 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/microsoft/azure-devops-go-api/azuredevops/work"
+	"slingshot.ninja/devops/iterationcapacity/capacity"
+)
+
+func TestExtractSprintNumber(t *testing.T) {
+	pattern, err := compileSprintRegex("", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := func(s string) *string { return &s }
+
+	tests := []struct {
+		name    string
+		input   *string
+		want    int
+		wantErr string
+	}{
+		{name: "nil name", input: nil, wantErr: "iteration name is nil"},
+		{name: "sprint number", input: name("Sprint 12"), want: 12},
+		{name: "extra spaces", input: name("Sprint   7"), want: 7},
+		{name: "no number", input: name("My Sprint"), wantErr: "iteration name does not contain sprint number"},
+		{
+			name:    "overflowing number",
+			input:   name("Sprint 99999999999999999999"),
+			wantErr: `could not parse sprint number from iteration name: strconv.Atoi: parsing "99999999999999999999": value out of range`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := extractSprintNumber(tt.input, pattern)
+			if tt.wantErr != "" {
+				if err == nil {
+					t.Fatalf("got %d, want error %q", got, tt.wantErr)
+				}
+				if err.Error() != tt.wantErr {
+					t.Errorf("error = %q, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckDuplicatePoints(t *testing.T) {
+	tests := []struct {
+		name       string
+		pointsData []PointsCompleted
+		wantErr    string
+	}{
+		{
+			name: "no duplicates",
+			pointsData: []PointsCompleted{
+				{SprintNumber: 11, Completed: 20, Calculate: true},
+				{SprintNumber: 12, Completed: 25, Calculate: true},
+			},
+		},
+		{
+			name: "identical duplicate",
+			pointsData: []PointsCompleted{
+				{SprintNumber: 12, Completed: 25, Calculate: true},
+				{SprintNumber: 12, Completed: 25, Calculate: true},
+			},
+			wantErr: "sprint 12 is listed 2 times",
+		},
+		{
+			name: "conflicting duplicate",
+			pointsData: []PointsCompleted{
+				{SprintNumber: 12, Completed: 25, Calculate: true},
+				{SprintNumber: 13, Completed: 30, Calculate: true},
+				{SprintNumber: 12, Completed: 18, Calculate: true},
+				{SprintNumber: 12, Completed: 25, Calculate: true},
+			},
+			wantErr: "sprint 12 is listed 3 times with conflicting values",
+		},
+		{
+			name: "same sprint of different teams",
+			pointsData: []PointsCompleted{
+				{SprintNumber: 12, Completed: 25, Calculate: true, Team: "Team A"},
+				{SprintNumber: 12, Completed: 18, Calculate: true, Team: "Team B"},
+				{SprintNumber: 12, Completed: 30, Calculate: true},
+			},
+		},
+		{
+			name: "team names differing in case",
+			pointsData: []PointsCompleted{
+				{SprintNumber: 12, Completed: 25, Calculate: true, Team: "Team A"},
+				{SprintNumber: 12, Completed: 18, Calculate: true, Team: "team a"},
+			},
+			wantErr: "sprint 12 of team Team A is listed 2 times with conflicting values",
+		},
+		{
+			name: "several duplicates",
+			pointsData: []PointsCompleted{
+				{SprintNumber: 12, Completed: 25, Calculate: true},
+				{SprintNumber: 14, Completed: 10},
+				{SprintNumber: 12, Completed: 25, Calculate: true},
+				{SprintNumber: 14, Completed: 10, Calculate: true},
+			},
+			wantErr: "sprint 12 is listed 2 times\nsprint 14 is listed 2 times with conflicting values",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkDuplicatePoints(tt.pointsData)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDedupSprints(t *testing.T) {
+	id := func(b byte) *uuid.UUID { return &uuid.UUID{b} }
+	name := func(s string) *string { return &s }
+	sprint := func(iterationID *uuid.UUID, iterationName string, number int) sprintIteration {
+		return sprintIteration{Iteration: work.TeamSettingsIteration{Id: iterationID, Name: name(iterationName)}, SprintNumber: number}
+	}
+
+	sprints := []sprintIteration{
+		sprint(id(1), "Sprint 12", 12),
+		sprint(id(2), "Sprint 13", 13),
+		// The same iteration again, as when two teams of a run share it
+		sprint(id(1), "Sprint 12", 12),
+		// A different iteration with the same sprint number
+		sprint(id(3), "Release\\Sprint 12", 12),
+	}
+	got := dedupSprints(sprints)
+	if len(got) != 2 {
+		t.Fatalf("got %d sprints, want 2", len(got))
+	}
+	if *got[0].Iteration.Id != *id(1) || *got[1].Iteration.Id != *id(2) {
+		t.Errorf("got iterations %v and %v, want the first of sprint 12 and sprint 13", got[0].Iteration.Id, got[1].Iteration.Id)
+	}
+}
+
+// writeTestFile writes the content to a file in a temporary directory and
+// returns its path.
+func writeTestFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadArgsFileMalformed(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{name: "string instead of number", content: `{"daysInSprint": "10"}`, wantErr: `field "daysInSprint" must be a number, got a JSON string`},
+		{name: "number instead of string", content: `{"team": 5}`, wantErr: `field "team" must be a string, got a JSON number`},
+		{name: "fraction instead of whole number", content: `{"sprintStart": 1.5}`, wantErr: `field "sprintStart" must be a whole number, got a JSON number 1.5`},
+		{name: "unknown field", content: `{"sprintStrat": 60}`, wantErr: `json: unknown field "sprintStrat"`},
+		{name: "syntax error", content: `{"team": "A",}`, wantErr: "invalid character '}' looking for beginning of object key string"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := readArgsFile(writeTestFile(t, "arguments.json", tt.content))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestReadPointsCompletedFileMalformed(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{name: "string instead of number", content: `[{"sprint": 12, "completed": "5"}]`, wantErr: `field "0.completed" must be a number, got a JSON string`},
+		{name: "string instead of boolean", content: `[{"sprint": 12, "calculate": "yes"}]`, wantErr: `field "0.calculate" must be true or false, got a JSON string`},
+		{name: "unknown field", content: `[{"sprint": 12, "points": 5}]`, wantErr: `json: unknown field "points"`},
+		{name: "object instead of array", content: `{"sprint": 12}`, wantErr: "cannot unmarshal object"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := readPointsCompletedFile(writeTestFile(t, "points_completed.json", tt.content))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFilterIterationsSkipsNilID(t *testing.T) {
+	pattern, err := compileSprintRegex("", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := func(s string) *string { return &s }
+	iterations := []work.TeamSettingsIteration{
+		{Id: &uuid.UUID{1}, Name: name("Sprint 11")},
+		{Id: nil, Name: name("Sprint 12")},
+		{Id: nil, Name: nil},
+		{Id: &uuid.UUID{3}, Name: name("Sprint 13")},
+	}
+
+	sprints, unparseable := filterIterations(iterations, pattern, 0, 0)
+	if len(unparseable) != 0 {
+		t.Errorf("unparseable = %q, want none", unparseable)
+	}
+	var numbers []int
+	for _, sprint := range sprints {
+		numbers = append(numbers, sprint.SprintNumber)
+	}
+	if len(numbers) != 2 || numbers[0] != 11 || numbers[1] != 13 {
+		t.Errorf("got sprints %v, want [11 13]", numbers)
+	}
+}
+
+func TestIsRejected(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "no error", err: nil, want: false},
+		{name: "unauthorized", err: &capacity.CapacityFetchError{StatusCode: http.StatusUnauthorized}, want: true},
+		{name: "forbidden", err: &capacity.CapacityFetchError{StatusCode: http.StatusForbidden}, want: true},
+		{name: "wrapped forbidden", err: fmt.Errorf("sprint 3: %w", &capacity.CapacityFetchError{StatusCode: http.StatusForbidden}), want: true},
+		{name: "not found", err: &capacity.CapacityFetchError{StatusCode: http.StatusNotFound}, want: false},
+		{name: "no response", err: &capacity.CapacityFetchError{Err: errors.New("timed out")}, want: false},
+		{name: "other error", err: errors.New("fetching completed points: 401"), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isRejected(tt.err); got != tt.want {
+				t.Errorf("isRejected(%v) = %t, want %t", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTeamCapacity(t *testing.T) {
+	data := capacity.CapacityData{
+		Teams: []capacity.TeamData{
+			{TeamId: "team-a", TeamCapacityPerDay: 4, TeamTotalDaysOff: 2},
+			{TeamId: "team-b", TeamCapacityPerDay: 2.5, TeamTotalDaysOff: 1},
+		},
+		TotalIterationCapacityPerDay: 6.5,
+		TotalIterationDaysOff:        3,
+	}
+	tests := []struct {
+		teamID      string
+		wantPerDay  float64
+		wantDaysOff int
+	}{
+		{teamID: "team-a", wantPerDay: 4, wantDaysOff: 2},
+		{teamID: "team-b", wantPerDay: 2.5, wantDaysOff: 1},
+		// Without an entry for the team the totals are used
+		{teamID: "team-c", wantPerDay: 6.5, wantDaysOff: 3},
+	}
+	for _, tt := range tests {
+		perDay, daysOff := teamCapacity(data, tt.teamID, tt.teamID, 1)
+		if perDay != tt.wantPerDay || daysOff != tt.wantDaysOff {
+			t.Errorf("teamCapacity(%s) = %g, %d; want %g, %d", tt.teamID, perDay, daysOff, tt.wantPerDay, tt.wantDaysOff)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// progress prints a processed/total counter on a single, rewritten line.
+// It is disabled when stdout is not a terminal, so piped and scheduled runs
+// are not cluttered with it. It is safe for concurrent use.
+type progress struct {
+	w         io.Writer
+	total     int
+	enabled   bool
+	mu        sync.Mutex
+	processed int
+	// width is the length of the counter line last printed.
+	width int
+}
+
+// shownProgress is the counter currently shown on stderr, which log records
+// written with progressLogWriter step around.
+var shownProgress atomic.Pointer[progress]
+
+func newProgress(total int) *progress {
+	p := &progress{
+		w:       os.Stderr,
+		total:   total,
+		enabled: isTerminal(os.Stdout),
+	}
+	shownProgress.Store(p)
+	return p
+}
+
+// isTerminal reports whether the file is a character device such as a TTY.
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// Increment records one more processed iteration.
+func (p *progress) Increment() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.processed++
+	p.print()
+}
+
+// Finish ends the counter line.
+func (p *progress) Finish() {
+	shownProgress.CompareAndSwap(p, nil)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.enabled || p.total == 0 {
+		return
+	}
+	fmt.Fprintln(p.w)
+}
+
+func (p *progress) print() {
+	if !p.enabled || p.total == 0 {
+		return
+	}
+	line := fmt.Sprintf("Processed %d/%d iterations (%d%%)", p.processed, p.total, p.processed*100/p.total)
+	p.width = len(line)
+	fmt.Fprintf(p.w, "\r%s", line)
+}
+
+// clear blanks the counter line and returns the cursor to its start.
+func (p *progress) clear() {
+	if !p.enabled || p.width == 0 {
+		return
+	}
+	fmt.Fprintf(p.w, "\r%s\r", strings.Repeat(" ", p.width))
+}
+
+// progressLogWriter writes log records to w. While a counter is shown on the
+// same stream, it clears the counter line before each record and redraws the
+// counter after it, so records and counter do not run into each other.
+type progressLogWriter struct {
+	w io.Writer
+}
+
+func (l progressLogWriter) Write(record []byte) (int, error) {
+	p := shownProgress.Load()
+	if p == nil || p.w != l.w {
+		return l.w.Write(record)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.clear()
+	n, err := l.w.Write(record)
+	if p.processed > 0 {
+		p.print()
+	}
+	return n, err
+}
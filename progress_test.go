@@ -0,0 +1,29 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestProgressLogWriterClearsCounter(t *testing.T) {
+	var out bytes.Buffer
+	bar := &progress{w: &out, total: 2, enabled: true}
+	shownProgress.Store(bar)
+	defer shownProgress.Store(nil)
+
+	bar.Increment()
+	if _, err := (progressLogWriter{w: &out}).Write([]byte("level=INFO msg=\"Working on sprint\"\n")); err != nil {
+		t.Fatal(err)
+	}
+	bar.Finish()
+
+	counter := "Processed 1/2 iterations (50%)"
+	want := "\r" + counter + "\r" + strings.Repeat(" ", len(counter)) + "\r" + "level=INFO msg=\"Working on sprint\"\n" + "\r" + counter + "\n"
+	if got := out.String(); got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+	if shownProgress.Load() != nil {
+		t.Error("the finished counter is still shown")
+	}
+}
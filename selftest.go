@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"slingshot.ninja/devops/iterationcapacity/capacity"
+)
+
+// selfTestTimeout bounds every network check of -selftest.
+const selfTestTimeout = 15 * time.Second
+
+// selfTestInput holds the files and database the checks of -selftest
+// validate, as given on the command line.
+type selfTestInput struct {
+	ConfigPath string
+	ArgsPath   string
+	PointsPath string
+	DBPath     string
+	DBDriver   string
+	// TeamListPath is the -team-list-file, whose teams replace the team
+	// of the arguments.
+	TeamListPath string
+}
+
+// errSkipped marks a check that could not run because an earlier check it
+// depends on failed.
+var errSkipped = errors.New("skipped")
+
+// selfTestResult is the outcome of one check; a nil Err means it passed.
+type selfTestResult struct {
+	Name string
+	Err  error
+}
+
+// runSelfTest validates the configuration, the connection to Azure DevOps,
+// the token and the database without processing any sprint, writes a line per
+// check and a summary to w, and reports whether every check passed. Checks
+// that need valid arguments are skipped when the arguments are invalid.
+func runSelfTest(ctx context.Context, w io.Writer, in selfTestInput) bool {
+	var results []selfTestResult
+	check := func(name string, err error) {
+		results = append(results, selfTestResult{Name: name, Err: err})
+	}
+
+	// Like in a normal run, the first team of the list stands in for the
+	// team of the arguments, which may be left out
+	var listedTeam string
+	var teamListErr error
+	if in.TeamListPath != "" {
+		var teams []string
+		teams, teamListErr = readTeamListFile(in.TeamListPath)
+		if teamListErr == nil {
+			listedTeam = teams[0]
+		}
+		check("team list file "+in.TeamListPath, teamListErr)
+	}
+	validate := func(args Args) error {
+		if listedTeam != "" {
+			args.Team = listedTeam
+		}
+		return validateArgs(args)
+	}
+
+	var args Args
+	var argsErr error
+	if in.ConfigPath != "" {
+		var config Config
+		config, argsErr = readConfigFile(in.ConfigPath)
+		if argsErr == nil {
+			args = config.Args
+			argsErr = validate(args)
+		}
+		check("config file "+in.ConfigPath, argsErr)
+	} else {
+		args, argsErr = readArgsFile(in.ArgsPath)
+		if argsErr == nil {
+			argsErr = validate(args)
+		}
+		check("arguments file "+in.ArgsPath, argsErr)
+		_, err := readPointsCompletedFiles(in.PointsPath)
+		if err != nil && argsErr == nil && pointsFileOptional(args, err) {
+			err = nil
+		}
+		check("points file "+in.PointsPath, err)
+	}
+
+	if listedTeam != "" {
+		args.Team = listedTeam
+	}
+	if argsErr != nil || teamListErr != nil {
+		check("connection to Azure DevOps", errSkipped)
+		check("token", errSkipped)
+	} else {
+		check("connection to Azure DevOps", checkConnectivity(ctx, args))
+		check("token", checkSelfTestToken(ctx, args))
+	}
+
+	databasePath := in.DBPath
+	if databasePath == "" && argsErr == nil {
+		databasePath = args.DBPath
+	}
+	if databasePath == "" && in.DBDriver == DriverSQLite {
+		databasePath = defaultDBPath
+	}
+	check("database", checkDatabase(ctx, in.DBDriver, databasePath))
+
+	passed := 0
+	for _, result := range results {
+		switch {
+		case result.Err == nil:
+			passed++
+			fmt.Fprintf(w, "PASS  %s\n", result.Name)
+		case errors.Is(result.Err, errSkipped):
+			fmt.Fprintf(w, "SKIP  %s: the arguments or the team list are invalid\n", result.Name)
+		default:
+			fmt.Fprintf(w, "FAIL  %s: %v\n", result.Name, result.Err)
+		}
+	}
+	fmt.Fprintf(w, "%d of %d checks passed\n", passed, len(results))
+	return passed == len(results)
+}
+
+// checkConnectivity reports whether the organization URL answers at all,
+// with the proxy and CA certificates of the arguments. Any HTTP response,
+// including a sign-in redirect or 401, means it is reachable.
+func checkConnectivity(ctx context.Context, args Args) error {
+	if err := configureProxy(args.ProxyURL); err != nil {
+		return err
+	}
+	if err := configureCACert(args.CACertFile); err != nil {
+		return fmt.Errorf("loading CA certificate file: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, selfTestTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, args.OrgURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// checkSelfTestToken makes the token check of a normal run for the team of
+// the arguments, or the first team of the team list.
+func checkSelfTestToken(ctx context.Context, args Args) error {
+	token, err := resolveToken(args)
+	if err != nil {
+		return err
+	}
+	apiVersion := args.APIVersion
+	if apiVersion == "" {
+		apiVersion = defaultAPIVersion
+	}
+	connection := capacity.NewConnection(args.OrgURL, token, args.AuthMode)
+	opts := capacity.FetchOptions{Timeout: selfTestTimeout, APIVersion: apiVersion}
+	return capacity.CheckToken(ctx, connection, args.Project, args.Team, opts)
+}
+
+// checkDatabase reports whether the database can be written without
+// touching its tables: for SQLite, whether the file and its directory, where
+// the journal is kept, are writable; for Postgres, whether it accepts a
+// connection.
+func checkDatabase(ctx context.Context, driver, path string) error {
+	switch driver {
+	case DriverSQLite:
+		dir := filepath.Dir(path)
+		probe, err := os.CreateTemp(dir, ".selftest-*")
+		if err != nil {
+			return fmt.Errorf("directory %s is not writable: %w", dir, err)
+		}
+		probe.Close()
+		os.Remove(probe.Name())
+
+		file, err := os.OpenFile(path, os.O_WRONLY, 0)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("database file is not writable: %w", err)
+		}
+		return file.Close()
+	case DriverPostgres:
+		if path == "" {
+			return errors.New("a connection string is required; set -db or dbPath")
+		}
+		db, err := sql.Open("postgres", path)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		ctx, cancel := context.WithTimeout(ctx, selfTestTimeout)
+		defer cancel()
+		return db.PingContext(ctx)
+	default:
+		_, err := newStore(driver, path, defaultTableName)
+		return err
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRunSelfTestTeamList(t *testing.T) {
+	var mu sync.Mutex
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		paths = append(paths, r.URL.Path)
+		mu.Unlock()
+		w.Write([]byte(`{"value": []}`))
+	}))
+	defer server.Close()
+
+	// The arguments have no team; the team list supplies it
+	argsPath := writeTestFile(t, "arguments.json", `{"orgURL": "`+server.URL+`", "token": "secret", "project": "Project", "daysInSprint": 10}`)
+	teamListPath := writeTestFile(t, "teams.txt", "# program teams\nTeam Alpha\nTeam Beta\n")
+	pointsPath := writeTestFile(t, "points_completed.json", `[]`)
+
+	var out strings.Builder
+	passed := runSelfTest(context.Background(), &out, selfTestInput{
+		ArgsPath:     argsPath,
+		PointsPath:   pointsPath,
+		DBPath:       filepath.Join(t.TempDir(), "data.sqlite"),
+		DBDriver:     DriverSQLite,
+		TeamListPath: teamListPath,
+	})
+	if !passed {
+		t.Fatalf("self-test failed:\n%s", out.String())
+	}
+	for _, want := range []string{"PASS  team list file " + teamListPath, "PASS  arguments file " + argsPath, "PASS  token", "6 of 6 checks passed"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output does not contain %q:\n%s", want, out.String())
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if want := "/Project/Team Alpha/_apis/work/teamsettings/iterations"; !slices.Contains(paths, want) {
+		t.Errorf("requests %q do not check the token for the first team at %q", paths, want)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// newRowsHandler serves the rows as JSON: /iterations returns all rows and
+// /iterations/{sprint} the rows of a single sprint.
+func newRowsHandler(rows []IterationCapacityRow) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/iterations", func(w http.ResponseWriter, r *http.Request) {
+		writeRowsResponse(w, rows)
+	})
+	mux.HandleFunc("/iterations/", func(w http.ResponseWriter, r *http.Request) {
+		sprint, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/iterations/"))
+		if err != nil {
+			http.Error(w, "sprint must be a number", http.StatusBadRequest)
+			return
+		}
+
+		var matching []IterationCapacityRow
+		for _, row := range rows {
+			if row.SprintNumber == sprint {
+				matching = append(matching, row)
+			}
+		}
+		if len(matching) == 0 {
+			http.Error(w, "sprint not found", http.StatusNotFound)
+			return
+		}
+		writeRowsResponse(w, matching)
+	})
+	return mux
+}
+
+func writeRowsResponse(w http.ResponseWriter, rows []IterationCapacityRow) {
+	if rows == nil {
+		rows = []IterationCapacityRow{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(rows); err != nil {
+		slog.Error("Error writing response", "err", err)
+	}
+}
+
+// serveRows serves the rows over HTTP on addr until the process receives
+// SIGINT, then shuts the server down cleanly.
+func serveRows(addr string, rows []IterationCapacityRow) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           newRowsHandler(rows),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- server.ListenAndServe()
+	}()
+	slog.Info("Serving iterations", "addr", addr)
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	slog.Info("Shutting down server")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+	if err := <-errCh; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,665 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"slingshot.ninja/devops/iterationcapacity/capacity"
+)
+
+// CapacityStore persists the iteration capacity rows and runs the averaging
+// and forecasting steps over them.
+type CapacityStore interface {
+	// Init opens the store and creates the capacity table if needed. When
+	// fresh is set, the capacity table and its team capacity table are
+	// dropped first; other tables in the database are kept.
+	Init(fresh bool) error
+	// InsertRow inserts the row, or updates the existing row with the same
+	// team and sprint number. An empty RawCapacity is stored as NULL.
+	InsertRow(row IterationCapacityRow) error
+	// UpdatePoints sets the days available, days off ratio, completed
+	// points, completion ratio, ratio status and skip flag of the row with
+	// the same team and sprint number, leaving its fetched capacity
+	// untouched.
+	UpdatePoints(row IterationCapacityRow) error
+	// InsertTeamCapacity stores the capacity of one team in a sprint, or
+	// updates the existing row with the same sprint number and team ID.
+	InsertTeamCapacity(sprintNumber int, data capacity.TeamData, runTimestamp string) error
+	// InsertSprints inserts or updates the rows and team capacities of the
+	// sprints like InsertRow and InsertTeamCapacity, in one transaction: on
+	// an error none of them is written.
+	InsertSprints(sprints []SprintRecord, runTimestamp string) error
+	// UpdateAverages sets the average completion ratio on all rows of the
+	// team, computed over the sprints of its baseline.
+	UpdateAverages(team string, baseline Baseline) error
+	// CompletionRatios returns the completion ratios of the sprints in the
+	// team's baseline, oldest first.
+	CompletionRatios(team string, baseline Baseline) ([]float64, error)
+	// UpdateForecast stores the calculator's forecast and forecast range on
+	// the current and future sprints of the team, together with the strategy
+	// name and average window that produced them. Past sprints and sprints
+	// marked to skip the forecast get none. Each sprint's forecast error is
+	// taken from forecastErrors by sprint number, and is NULL when absent.
+	UpdateForecast(team string, calculator capacity.Calculator, strategy string, window int, forecastErrors map[int]float64) error
+	// AllRows returns every row ordered by team and sprint number.
+	AllRows() ([]IterationCapacityRow, error)
+	// Schema returns the CREATE TABLE statements of the store's tables.
+	Schema() []string
+	Close() error
+}
+
+// SprintRecord is the row of a sprint together with the capacities of the
+// individual teams in it.
+type SprintRecord struct {
+	Row   IterationCapacityRow
+	Teams []capacity.TeamData
+}
+
+// Baseline selects the sprints whose completion ratios make up a team's
+// velocity: its calculated sprints with completed points that are not marked
+// to skip the forecast. Sprints whose points are unknown are stored with -1
+// points and never count.
+type Baseline struct {
+	// Window limits the baseline to the last Window sprints by sprint
+	// number; zero means all sprints.
+	Window int
+	// IncludeZeroPoints also counts sprints in which no points were
+	// completed.
+	IncludeZeroPoints bool
+	// ExcludeCurrent leaves out the sprint in progress, whose points are
+	// still incomplete. It keeps its capacity and forecast.
+	ExcludeCurrent bool
+}
+
+// includes reports whether the row is one of the sprints of a baseline,
+// matching baselineCondition apart from the window, which depends on the
+// other rows of the team.
+func (b Baseline) includes(row IterationCapacityRow) bool {
+	if row.RatioStatus != capacity.RatioCalculated || row.SkipForecast {
+		return false
+	}
+	if b.ExcludeCurrent && row.Timeframe == TimeframeCurrent {
+		return false
+	}
+	return row.PointsCompleted > 0 || (b.IncludeZeroPoints && row.PointsCompleted == 0)
+}
+
+// Supported values of the -db-driver flag.
+const (
+	DriverSQLite   = "sqlite3"
+	DriverPostgres = "postgres"
+)
+
+// newStore returns the store for the driver. For SQLite the dsn is the path
+// of the database file, for Postgres a connection string.
+func newStore(driver, dsn, table string) (CapacityStore, error) {
+	switch driver {
+	case DriverSQLite:
+		return newSQLiteStore(dsn, table), nil
+	case DriverPostgres:
+		return newPostgresStore(dsn, table), nil
+	default:
+		return nil, fmt.Errorf("unknown database driver %q, expected %q or %q", driver, DriverSQLite, DriverPostgres)
+	}
+}
+
+// sqlDialect captures the SQL differences between the supported databases.
+type sqlDialect struct {
+	// idColumn is the definition of the auto-incrementing primary key.
+	idColumn string
+	// realType is the column type used for fractional numbers.
+	realType string
+	// numberedPlaceholders selects $1, $2, ... instead of ? placeholders.
+	numberedPlaceholders bool
+	// noLimit is the LIMIT argument that disables the limit.
+	noLimit any
+}
+
+// sqlStore implements the CapacityStore queries shared by all SQL databases.
+type sqlStore struct {
+	db      *sql.DB
+	table   string
+	dialect sqlDialect
+}
+
+// columnDescriptions explains the columns of the capacity table, in table
+// order, for -print-schema.
+var columnDescriptions = [][2]string{
+	{"id", "Row number"},
+	{"name", "Name of the iteration, e.g. Sprint 67"},
+	{"sprint_number", "Sprint number parsed from the iteration name"},
+	{"days_available", "Person-days available: capacity per day times the working days of the sprint, minus the days off"},
+	{"capacity_per_day", "Capacity per day of the team in the iteration; the total of all teams when the capacities have no entry for the team"},
+	{"days_off", "Days off of the team in the iteration; the total of all teams when the capacities have no entry for the team"},
+	{"points_completed", "Story points completed, from the points file; -1 when unknown"},
+	{"pnts_complete_for_totaldays", "Completion ratio: points completed per day available"},
+	{"avg_pnts_complete", "Average completion ratio of the team's calculated sprints"},
+	{"forecasted_completed", "Forecast of the points the team will complete; NULL for past and skipped sprints"},
+	{"team", "Team the row belongs to"},
+	{"run_timestamp", "UTC time of the run that last wrote the row"},
+	{"ratio_status", "Whether the completion ratio was calculated: calculated, not_calculated or no_capacity"},
+	{"timeframe", "Time frame of the iteration in Azure DevOps: past, current, future or unknown"},
+	{"forecast_strategy", "Forecast strategy that produced the forecast"},
+	{"avg_window", "Number of recent sprints averaged for the forecast; 0 means all"},
+	{"skip_forecast", "Whether the sprint is kept out of the average and the forecast"},
+	{"forecast_low", "Low end of the forecast range: one standard deviation below the forecast"},
+	{"forecast_high", "High end of the forecast range: one standard deviation above the forecast"},
+	{"forecast_error", "Points completed minus the forecast for the sprint's days available from the team's earlier sprints; NULL unless the sprint is past, its completion ratio was calculated and an earlier sprint was"},
+	{"raw_capacity", "Raw capacity response from Azure DevOps, when stored with -store-raw"},
+	{"unit", "Unit the completed and forecasted amounts are counted in, e.g. points or hours"},
+	{"days_off_ratio", "Share of the sprint's capacity lost to days off: days off divided by capacity per day times the working days; NULL without capacity"},
+	{"days_off_subtracted", "Whether the days off were subtracted from days_available; NULL in rows written before the column existed means they were"},
+	{"start_date", "Start date of the iteration as YYYY-MM-DD; NULL when not set in Azure DevOps"},
+	{"end_date", "Finish date of the iteration as YYYY-MM-DD; NULL when not set in Azure DevOps"},
+}
+
+// teamCapacityTable returns the quoted name of the table holding the
+// capacity of the individual teams per sprint. Every capacity table has its
+// own, so capacity tables sharing a database do not share it; the default
+// table keeps the name team_capacity.
+func (s *sqlStore) teamCapacityTable() string {
+	if s.table == defaultTableName {
+		return quoteIdentifier("team_capacity")
+	}
+	return quoteIdentifier(s.table + "_team_capacity")
+}
+
+// dropTables drops the capacity table and its team capacity table, leaving
+// other tables in the database alone. Dropping rather than deleting the
+// rows also replaces the schema of a table written by an older version.
+func (s *sqlStore) dropTables() error {
+	for _, table := range []string{quoteIdentifier(s.table), s.teamCapacityTable()} {
+		if _, err := s.db.Exec(fmt.Sprintf(`DROP TABLE IF EXISTS %s`, table)); err != nil {
+			return fmt.Errorf("dropping tables: %w", err)
+		}
+	}
+	return nil
+}
+
+// query formats the statement with the quoted table name and rewrites the ?
+// placeholders for the dialect.
+func (s *sqlStore) query(statement string) string {
+	return s.rebind(fmt.Sprintf(statement, quoteIdentifier(s.table)))
+}
+
+// rebind rewrites the ? placeholders of the statement for the dialect.
+func (s *sqlStore) rebind(statement string) string {
+	if !s.dialect.numberedPlaceholders {
+		return statement
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range statement {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// limit converts a window size into a LIMIT argument, where zero means no
+// limit.
+func (s *sqlStore) limit(window int) any {
+	if window <= 0 {
+		return s.dialect.noLimit
+	}
+	return window
+}
+
+// Schema returns the CREATE TABLE statements of the capacity table and the
+// team capacity table.
+func (s *sqlStore) Schema() []string {
+	return []string{
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	id %s,
+	name TEXT,
+	sprint_number INTEGER,
+	days_available %[3]s,
+	capacity_per_day %[3]s,
+	days_off INTEGER,
+	points_completed %[3]s,
+	pnts_complete_for_totaldays %[3]s,
+	avg_pnts_complete %[3]s,
+	forecasted_completed INTEGER,
+	team TEXT,
+	run_timestamp TEXT,
+	ratio_status TEXT,
+	timeframe TEXT,
+	forecast_strategy TEXT,
+	avg_window INTEGER,
+	skip_forecast BOOLEAN,
+	forecast_low INTEGER,
+	forecast_high INTEGER,
+	forecast_error %[3]s,
+	raw_capacity TEXT,
+	unit TEXT,
+	days_off_ratio %[3]s,
+	days_off_subtracted BOOLEAN,
+	start_date TEXT,
+	end_date TEXT,
+	UNIQUE (team, sprint_number)
+)`, quoteIdentifier(s.table), s.dialect.idColumn, s.dialect.realType),
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	id %s,
+	sprint_number INTEGER,
+	team_id TEXT,
+	capacity_per_day %[3]s,
+	days_off INTEGER,
+	run_timestamp TEXT,
+	UNIQUE (sprint_number, team_id)
+)`, s.teamCapacityTable(), s.dialect.idColumn, s.dialect.realType),
+	}
+}
+
+// createTable creates the tables of Schema, and adds the columns that the
+// tables of a database written by an older version lack.
+func (s *sqlStore) createTable() error {
+	tables := []string{quoteIdentifier(s.table), s.teamCapacityTable()}
+	for i, statement := range s.Schema() {
+		if _, err := s.db.Exec(statement); err != nil {
+			return fmt.Errorf("creating table: %w", err)
+		}
+		if err := s.addMissingColumns(tables[i], statement); err != nil {
+			return fmt.Errorf("updating table %s: %w", tables[i], err)
+		}
+	}
+	return nil
+}
+
+// addedColumnDefaults are the values that existing rows get when one of
+// these columns is added, since they are read into fields that cannot hold
+// NULL. The ratio status is derived like the completion ratio is. Other added
+// columns are NULL in existing rows.
+var addedColumnDefaults = map[string]string{
+	"team":          "''",
+	"run_timestamp": "''",
+	"ratio_status":  "CASE WHEN days_available < 1 THEN 'no_capacity' WHEN points_completed < 0 THEN 'not_calculated' ELSE 'calculated' END",
+	"timeframe":     "'" + TimeframeUnknown + "'",
+	"skip_forecast": "FALSE",
+	"unit":          "''",
+}
+
+// columnDefinitions returns the name and type of every column of a CREATE
+// TABLE statement of Schema, except the id column.
+func columnDefinitions(statement string) [][2]string {
+	var columns [][2]string
+	for _, line := range strings.Split(statement, "\n")[1:] {
+		line = strings.TrimSuffix(strings.TrimSpace(line), ",")
+		name, definition, ok := strings.Cut(line, " ")
+		if !ok || name == "id" || name == "UNIQUE" {
+			continue
+		}
+		columns = append(columns, [2]string{name, definition})
+	}
+	return columns
+}
+
+// addMissingColumns adds the columns of the CREATE TABLE statement that the
+// table lacks, so -append keeps working on a database written by an older
+// version. A table from before rows were keyed on team also gets the unique
+// index the upserts rely on.
+func (s *sqlStore) addMissingColumns(table, statement string) error {
+	rows, err := s.db.Query(fmt.Sprintf(`SELECT * FROM %s LIMIT 0`, table))
+	if err != nil {
+		return err
+	}
+	names, err := rows.Columns()
+	rows.Close()
+	if err != nil {
+		return err
+	}
+	existing := make(map[string]bool)
+	for _, name := range names {
+		existing[strings.ToLower(name)] = true
+	}
+
+	for _, column := range columnDefinitions(statement) {
+		name, definition := column[0], column[1]
+		if existing[name] {
+			continue
+		}
+		if _, err := s.db.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s`, table, name, definition)); err != nil {
+			return fmt.Errorf("adding column %s: %w", name, err)
+		}
+		if value, ok := addedColumnDefaults[name]; ok {
+			if _, err := s.db.Exec(fmt.Sprintf(`UPDATE %s SET %s = %s`, table, name, value)); err != nil {
+				return fmt.Errorf("filling column %s: %w", name, err)
+			}
+		}
+		slog.Info("Added a column missing from a table written by an older version", "table", table, "column", name)
+
+		if name == "team" {
+			index := quoteIdentifier(strings.Trim(table, `"`) + "_team_sprint_number")
+			if _, err := s.db.Exec(fmt.Sprintf(`CREATE UNIQUE INDEX IF NOT EXISTS %s ON %s (team, sprint_number)`, index, table)); err != nil {
+				return fmt.Errorf("adding unique index on team and sprint number: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// execer runs a statement on the database or within a transaction.
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+func (s *sqlStore) InsertRow(row IterationCapacityRow) error {
+	return s.insertRow(s.db, row)
+}
+
+func (s *sqlStore) insertRow(db execer, row IterationCapacityRow) error {
+	var rawCapacity, startDate, endDate any
+	if row.RawCapacity != "" {
+		rawCapacity = row.RawCapacity
+	}
+	if row.StartDate != "" {
+		startDate = row.StartDate
+	}
+	if row.EndDate != "" {
+		endDate = row.EndDate
+	}
+	_, err := db.Exec(s.query(`INSERT INTO %s (
+		name, sprint_number, days_available, capacity_per_day, days_off, points_completed, pnts_complete_for_totaldays,
+		team, run_timestamp, ratio_status, timeframe, skip_forecast, raw_capacity, unit, days_off_ratio,
+		days_off_subtracted, start_date, end_date
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (team, sprint_number) DO UPDATE SET
+		name = excluded.name,
+		days_available = excluded.days_available,
+		capacity_per_day = excluded.capacity_per_day,
+		days_off = excluded.days_off,
+		points_completed = excluded.points_completed,
+		pnts_complete_for_totaldays = excluded.pnts_complete_for_totaldays,
+		run_timestamp = excluded.run_timestamp,
+		ratio_status = excluded.ratio_status,
+		timeframe = excluded.timeframe,
+		skip_forecast = excluded.skip_forecast,
+		raw_capacity = excluded.raw_capacity,
+		unit = excluded.unit,
+		days_off_ratio = excluded.days_off_ratio,
+		days_off_subtracted = excluded.days_off_subtracted,
+		start_date = excluded.start_date,
+		end_date = excluded.end_date`),
+		row.Name,
+		row.SprintNumber,
+		row.DaysAvailable,
+		row.CapacityPerDay,
+		row.DaysOff,
+		row.PointsCompleted,
+		row.PointsCompletedForTotalDays,
+		row.Team,
+		row.RunTimestamp,
+		row.RatioStatus,
+		row.Timeframe,
+		row.SkipForecast,
+		rawCapacity,
+		row.Unit,
+		row.DaysOffRatio,
+		row.DaysOffSubtracted,
+		startDate,
+		endDate)
+	return err
+}
+
+func (s *sqlStore) UpdatePoints(row IterationCapacityRow) error {
+	_, err := s.db.Exec(s.query(`UPDATE %s SET
+		days_available = ?, days_off_ratio = ?, points_completed = ?, pnts_complete_for_totaldays = ?, ratio_status = ?, skip_forecast = ?
+		WHERE team = ? AND sprint_number = ?`),
+		row.DaysAvailable,
+		row.DaysOffRatio,
+		row.PointsCompleted,
+		row.PointsCompletedForTotalDays,
+		row.RatioStatus,
+		row.SkipForecast,
+		row.Team,
+		row.SprintNumber)
+	return err
+}
+
+func (s *sqlStore) InsertTeamCapacity(sprintNumber int, data capacity.TeamData, runTimestamp string) error {
+	return s.insertTeamCapacity(s.db, sprintNumber, data, runTimestamp)
+}
+
+func (s *sqlStore) insertTeamCapacity(db execer, sprintNumber int, data capacity.TeamData, runTimestamp string) error {
+	_, err := db.Exec(s.rebind(`INSERT INTO `+s.teamCapacityTable()+` (
+		sprint_number, team_id, capacity_per_day, days_off, run_timestamp
+		) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (sprint_number, team_id) DO UPDATE SET
+		capacity_per_day = excluded.capacity_per_day,
+		days_off = excluded.days_off,
+		run_timestamp = excluded.run_timestamp`),
+		sprintNumber,
+		data.TeamId,
+		data.TeamCapacityPerDay,
+		data.TeamTotalDaysOff,
+		runTimestamp)
+	return err
+}
+
+func (s *sqlStore) InsertSprints(sprints []SprintRecord, runTimestamp string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("beginning transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, sprint := range sprints {
+		if err := s.insertRow(tx, sprint.Row); err != nil {
+			return fmt.Errorf("inserting sprint %d: %w", sprint.Row.SprintNumber, err)
+		}
+		for _, team := range sprint.Teams {
+			if err := s.insertTeamCapacity(tx, sprint.Row.SprintNumber, team, runTimestamp); err != nil {
+				return fmt.Errorf("inserting capacity of team %s in sprint %d: %w", team.TeamId, sprint.Row.SprintNumber, err)
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// baselineCondition is the WHERE condition of the sprints in a baseline,
+// taking the arguments of baselineArgs.
+const baselineCondition = `(points_completed > 0 OR (? AND points_completed = 0)) AND NOT (? AND timeframe = ?) AND ratio_status = ? AND skip_forecast = ? AND team = ?`
+
+func baselineArgs(team string, baseline Baseline) []any {
+	return []any{baseline.IncludeZeroPoints, baseline.ExcludeCurrent, TimeframeCurrent, capacity.RatioCalculated, false, team}
+}
+
+func (s *sqlStore) UpdateAverages(team string, baseline Baseline) error {
+	_, err := s.db.Exec(s.query(`UPDATE %[1]s 
+		SET avg_pnts_complete = (SELECT AVG(pnts_complete_for_totaldays) FROM (
+			SELECT pnts_complete_for_totaldays FROM %[1]s
+			WHERE `+baselineCondition+`
+			ORDER BY sprint_number DESC LIMIT ?) AS recent)
+		WHERE team = ?`),
+		append(baselineArgs(team, baseline), s.limit(baseline.Window), team)...)
+	return err
+}
+
+func (s *sqlStore) CompletionRatios(team string, baseline Baseline) ([]float64, error) {
+	rows, err := s.db.Query(s.query(`SELECT pnts_complete_for_totaldays FROM (
+		SELECT sprint_number, pnts_complete_for_totaldays FROM %s
+		WHERE `+baselineCondition+`
+		ORDER BY sprint_number DESC LIMIT ?) AS recent
+		ORDER BY sprint_number`), append(baselineArgs(team, baseline), s.limit(baseline.Window))...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ratios []float64
+	for rows.Next() {
+		var ratio float64
+		if err := rows.Scan(&ratio); err != nil {
+			return nil, err
+		}
+		ratios = append(ratios, ratio)
+	}
+	return ratios, rows.Err()
+}
+
+func (s *sqlStore) UpdateForecast(team string, calculator capacity.Calculator, strategy string, window int, forecastErrors map[int]float64) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("beginning transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	type forecastInput struct {
+		id                int
+		sprint_number     int
+		points_completed  float64
+		avg_pnts_complete sql.NullFloat64
+		days_available    float64
+		timeframe         string
+		skip_forecast     bool
+	}
+
+	// Read all rows before updating, since not every driver allows a
+	// statement to run while a result set is still open.
+	rowsY, err := tx.Query(s.query(`SELECT id, sprint_number, points_completed, avg_pnts_complete, days_available, timeframe, skip_forecast FROM %s WHERE team = ?`), team)
+	if err != nil {
+		return fmt.Errorf("selecting rows: %w", err)
+	}
+	var inputs []forecastInput
+	for rowsY.Next() {
+		var input forecastInput
+		err := rowsY.Scan(&input.id, &input.sprint_number, &input.points_completed, &input.avg_pnts_complete, &input.days_available, &input.timeframe, &input.skip_forecast)
+		if err != nil {
+			slog.Error("Error scanning row", "err", err)
+			continue
+		}
+		inputs = append(inputs, input)
+	}
+	rowsY.Close()
+	if err := rowsY.Err(); err != nil {
+		return fmt.Errorf("selecting rows: %w", err)
+	}
+
+	for _, input := range inputs {
+		// A past sprint is done, so only its completed points matter. Without an
+		// average there is no velocity to forecast from.
+		var forecastedCompleted, forecastLow, forecastHigh, forecastStrategy, avgWindow any
+		if input.timeframe != TimeframePast && !input.skip_forecast && input.avg_pnts_complete.Valid {
+			avg := input.avg_pnts_complete.Float64
+			forecastedCompleted = calculator.Forecast(input.days_available, input.points_completed, avg)
+			forecastLow, forecastHigh = calculator.ForecastRange(input.days_available, input.points_completed, avg)
+			forecastStrategy, avgWindow = strategy, window
+		}
+		var forecastError any
+		if value, ok := forecastErrors[input.sprint_number]; ok {
+			forecastError = value
+		}
+		slog.Debug("Forecast calculated", "id", input.id, "forecast", forecastedCompleted, "forecastError", forecastError)
+
+		_, err = tx.Exec(s.query(`UPDATE %s 
+			SET forecasted_completed = ?, forecast_low = ?, forecast_high = ?, forecast_strategy = ?, avg_window = ?, forecast_error = ?
+			WHERE id = ?`),
+			forecastedCompleted, forecastLow, forecastHigh, forecastStrategy, avgWindow, forecastError, input.id)
+		if err != nil {
+			return fmt.Errorf("updating rows: %w", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *sqlStore) AllRows() ([]IterationCapacityRow, error) {
+	rows, err := s.db.Query(s.query(`SELECT id, name, sprint_number, days_available, capacity_per_day, days_off,
+		points_completed, pnts_complete_for_totaldays, avg_pnts_complete, forecasted_completed, team, run_timestamp, ratio_status, timeframe,
+		forecast_strategy, avg_window, skip_forecast, forecast_low, forecast_high, forecast_error, raw_capacity, unit, days_off_ratio,
+		days_off_subtracted, start_date, end_date
+		FROM %s ORDER BY team, sprint_number`))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []IterationCapacityRow
+	for rows.Next() {
+		var row IterationCapacityRow
+		var forecasted_completed sql.NullInt64
+		var avg_pnts_complete sql.NullFloat64
+		var forecast_strategy sql.NullString
+		var avg_window sql.NullInt64
+		var forecast_low, forecast_high sql.NullInt64
+		var forecast_error sql.NullFloat64
+		var raw_capacity sql.NullString
+		var days_off_ratio sql.NullFloat64
+		var days_off_subtracted sql.NullBool
+		var start_date, end_date sql.NullString
+		err := rows.Scan(
+			&row.ID,
+			&row.Name,
+			&row.SprintNumber,
+			&row.DaysAvailable,
+			&row.CapacityPerDay,
+			&row.DaysOff,
+			&row.PointsCompleted,
+			&row.PointsCompletedForTotalDays,
+			&avg_pnts_complete,
+			&forecasted_completed,
+			&row.Team,
+			&row.RunTimestamp,
+			&row.RatioStatus,
+			&row.Timeframe,
+			&forecast_strategy,
+			&avg_window,
+			&row.SkipForecast,
+			&forecast_low,
+			&forecast_high,
+			&forecast_error,
+			&raw_capacity,
+			&row.Unit,
+			&days_off_ratio,
+			&days_off_subtracted,
+			&start_date,
+			&end_date)
+		if err != nil {
+			return nil, err
+		}
+		if days_off_ratio.Valid {
+			ratio := days_off_ratio.Float64
+			row.DaysOffRatio = &ratio
+		}
+		if avg_pnts_complete.Valid {
+			avg := avg_pnts_complete.Float64
+			row.AvgPointsCompleted = &avg
+		}
+		if forecasted_completed.Valid {
+			forecast := forecasted_completed.Int64
+			row.ForecastedCompleted = &forecast
+		}
+		if forecast_low.Valid && forecast_high.Valid {
+			low, high := forecast_low.Int64, forecast_high.Int64
+			row.ForecastLow = &low
+			row.ForecastHigh = &high
+		}
+		if forecast_error.Valid {
+			forecastError := forecast_error.Float64
+			row.ForecastError = &forecastError
+		}
+		row.ForecastStrategy = forecast_strategy.String
+		row.RawCapacity = raw_capacity.String
+		row.StartDate, row.EndDate = start_date.String, end_date.String
+		row.DaysOffSubtracted = !days_off_subtracted.Valid || days_off_subtracted.Bool
+		if avg_window.Valid {
+			window := avg_window.Int64
+			row.AvgWindow = &window
+		}
+		result = append(result, row)
+	}
+	return result, rows.Err()
+}
+
+func (s *sqlStore) Close() error {
+	if s.db == nil {
+		return nil
+	}
+	return s.db.Close()
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"database/sql"
+
+	_ "github.com/lib/pq"
+)
+
+// postgresStore keeps the capacity table in a shared Postgres database.
+type postgresStore struct {
+	sqlStore
+	dsn string
+}
+
+func newPostgresStore(dsn, table string) *postgresStore {
+	return &postgresStore{
+		sqlStore: sqlStore{
+			table: table,
+			dialect: sqlDialect{
+				idColumn:             "SERIAL PRIMARY KEY",
+				realType:             "DOUBLE PRECISION",
+				numberedPlaceholders: true,
+				noLimit:              nil,
+			},
+		},
+		dsn: dsn,
+	}
+}
+
+// Init connects to the database. When fresh is set, the capacity tables are
+// dropped first so no history is kept.
+func (s *postgresStore) Init(fresh bool) error {
+	db, err := sql.Open("postgres", s.dsn)
+	if err != nil {
+		return err
+	}
+	s.db = db
+
+	if fresh {
+		if err := s.dropTables(); err != nil {
+			return err
+		}
+	}
+
+	return s.createTable()
+}
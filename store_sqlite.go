@@ -0,0 +1,48 @@
+package main
+
+import (
+	"database/sql"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// sqliteStore keeps the capacity table in a local SQLite database file.
+type sqliteStore struct {
+	sqlStore
+	path string
+}
+
+func newSQLiteStore(path, table string) *sqliteStore {
+	return &sqliteStore{
+		sqlStore: sqlStore{
+			table: table,
+			dialect: sqlDialect{
+				idColumn: "INTEGER PRIMARY KEY AUTOINCREMENT",
+				realType: "REAL",
+				noLimit:  -1,
+			},
+		},
+		path: path,
+	}
+}
+
+// Init opens the database file, creating it if needed. When fresh is set,
+// the capacity table and its team capacity table are dropped and recreated
+// so no history is kept, while the tables of other table names in the same
+// file are kept.
+func (s *sqliteStore) Init(fresh bool) error {
+	// Open the database file - Important! Ignore file in Git */
+	db, err := sql.Open("sqlite3", s.path)
+	if err != nil {
+		return err
+	}
+	s.db = db
+
+	if fresh {
+		if err := s.dropTables(); err != nil {
+			return err
+		}
+	}
+
+	return s.createTable()
+}
@@ -0,0 +1,450 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"slingshot.ninja/devops/iterationcapacity/capacity"
+)
+
+// newTestStore returns a fresh SQLite store in a temporary directory.
+func newTestStore(t *testing.T) CapacityStore {
+	t.Helper()
+	store, err := newStore(DriverSQLite, filepath.Join(t.TempDir(), "data.sqlite"), defaultTableName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Init(true); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+// insertTestSprint stores a sprint of the team with its completion ratio
+// computed from the points and days available; -1 points means unknown.
+func insertTestSprint(t *testing.T, store CapacityStore, team string, sprintNumber int, daysAvailable, points float64, timeframe string) {
+	t.Helper()
+	ratio, status := capacity.Calculator{}.CompletionRatio(points, daysAvailable)
+	row := IterationCapacityRow{
+		Team:                        team,
+		Name:                        fmt.Sprintf("Sprint %d", sprintNumber),
+		SprintNumber:                sprintNumber,
+		DaysAvailable:               daysAvailable,
+		PointsCompleted:             points,
+		PointsCompletedForTotalDays: ratio,
+		RatioStatus:                 status,
+		Timeframe:                   timeframe,
+		DaysOffSubtracted:           true,
+	}
+	if err := store.InsertRow(row); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// averageOf returns the stored average of the team's first row, or nil.
+func averageOf(t *testing.T, store CapacityStore, team string) *float64 {
+	t.Helper()
+	rows, err := store.AllRows()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range rows {
+		if row.Team == team {
+			return row.AvgPointsCompleted
+		}
+	}
+	t.Fatalf("no rows for team %q", team)
+	return nil
+}
+
+func TestUpdateAveragesWindow(t *testing.T) {
+	store := newTestStore(t)
+	// Ratios 1, 2, 3 and 4, then a sprint without points that is not part
+	// of the baseline and so does not take a place in the window
+	for sprint := 1; sprint <= 4; sprint++ {
+		insertTestSprint(t, store, "Team A", sprint, 10, float64(sprint*10), TimeframePast)
+	}
+	insertTestSprint(t, store, "Team A", 5, 10, -1, TimeframeFuture)
+
+	tests := []struct {
+		window int
+		want   float64
+		ratios []float64
+	}{
+		{window: 0, want: 2.5, ratios: []float64{1, 2, 3, 4}},
+		{window: 1, want: 4, ratios: []float64{4}},
+		{window: 2, want: 3.5, ratios: []float64{3, 4}},
+		{window: 4, want: 2.5, ratios: []float64{1, 2, 3, 4}},
+		{window: 5, want: 2.5, ratios: []float64{1, 2, 3, 4}},
+	}
+	for _, tt := range tests {
+		baseline := Baseline{Window: tt.window}
+		if err := store.UpdateAverages("Team A", baseline); err != nil {
+			t.Fatal(err)
+		}
+		if got := averageOf(t, store, "Team A"); got == nil || *got != tt.want {
+			t.Errorf("window %d: average = %v, want %g", tt.window, got, tt.want)
+		}
+		ratios, err := store.CompletionRatios("Team A", baseline)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(ratios, tt.ratios) {
+			t.Errorf("window %d: ratios = %v, want %v", tt.window, ratios, tt.ratios)
+		}
+	}
+}
+
+func TestInitAddsMissingColumns(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.sqlite")
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The table as the first version of the program wrote it
+	_, err = db.Exec(`CREATE TABLE iteration_capacity (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT,
+		sprint_number INTEGER,
+		days_available REAL,
+		capacity_per_day REAL,
+		days_off INTEGER,
+		points_completed INTEGER,
+		pnts_complete_for_totaldays REAL,
+		avg_pnts_complete REAL,
+		forecasted_completed INTEGER
+	)`)
+	if err == nil {
+		_, err = db.Exec(`INSERT INTO iteration_capacity (name, sprint_number, days_available, capacity_per_day, days_off, points_completed, pnts_complete_for_totaldays)
+			VALUES ('Sprint 1', 1, 10, 1, 0, 20, 2), ('Sprint 2', 2, 10, 1, 0, -1, 0)`)
+	}
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := newStore(DriverSQLite, path, defaultTableName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Init(false); err != nil {
+		t.Fatalf("Init on an old database: %v", err)
+	}
+	defer store.Close()
+
+	// The upsert needs the unique index on team and sprint number
+	for i := 0; i < 2; i++ {
+		row := IterationCapacityRow{Team: "Team A", SprintNumber: 3, Name: "Sprint 3", DaysAvailable: 10, RatioStatus: capacity.RatioNotCalculated, Timeframe: TimeframeFuture, DaysOffSubtracted: true}
+		if err := store.InsertRow(row); err != nil {
+			t.Fatalf("InsertRow: %v", err)
+		}
+	}
+	rows, err := store.AllRows()
+	if err != nil {
+		t.Fatalf("AllRows: %v", err)
+	}
+	if len(rows) != 3 {
+		t.Fatalf("got %d rows, want 3", len(rows))
+	}
+	old := rows[0]
+	if old.Team != "" || old.Timeframe != TimeframeUnknown || old.RatioStatus != capacity.RatioCalculated || !old.DaysOffSubtracted {
+		t.Errorf("old sprint 1 = team %q, timeframe %q, ratio status %q, days off subtracted %v; want \"\", %q, %q, true",
+			old.Team, old.Timeframe, old.RatioStatus, old.DaysOffSubtracted, TimeframeUnknown, capacity.RatioCalculated)
+	}
+	if rows[1].RatioStatus != capacity.RatioNotCalculated {
+		t.Errorf("old sprint 2 ratio status = %q, want %q", rows[1].RatioStatus, capacity.RatioNotCalculated)
+	}
+
+	// The old rows keep NULL in days_off_subtracted, as its column
+	// description says, rather than a made-up value
+	db, err = sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var nulls int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM iteration_capacity WHERE days_off_subtracted IS NULL`).Scan(&nulls); err != nil {
+		t.Fatal(err)
+	}
+	if nulls != 2 {
+		t.Errorf("%d rows have no days_off_subtracted, want the 2 old rows", nulls)
+	}
+}
+
+func TestInsertSprintsTeamsSharingIteration(t *testing.T) {
+	store := newTestStore(t)
+	// The capacities of the shared iteration cover both teams
+	shared := capacity.CapacityData{
+		Teams: []capacity.TeamData{
+			{TeamId: "team-a", TeamCapacityPerDay: 4, TeamTotalDaysOff: 2},
+			{TeamId: "team-b", TeamCapacityPerDay: 2, TeamTotalDaysOff: 0},
+		},
+		TotalIterationCapacityPerDay: 6,
+		TotalIterationDaysOff:        2,
+	}
+	record := func(team, teamID string, points float64) SprintRecord {
+		perDay, daysOff := teamCapacity(shared, teamID, team, 12)
+		daysAvailable := capacity.Calculator{}.DaysAvailable(perDay, 10, daysOff)
+		return SprintRecord{Row: IterationCapacityRow{Team: team, Name: "Sprint 12", SprintNumber: 12, DaysAvailable: daysAvailable, CapacityPerDay: perDay, DaysOff: daysOff,
+			PointsCompleted: points, Timeframe: TimeframePast, DaysOffSubtracted: true}, Teams: shared.Teams}
+	}
+
+	// Both teams get a row for the shared iteration, and a second run
+	// updates those rows rather than adding more
+	for run := 0; run < 2; run++ {
+		records := []SprintRecord{record("Team A", "team-a", float64(20+run)), record("Team B", "team-b", float64(30+run))}
+		if err := store.InsertSprints(records, "2026-10-16T00:00:00Z"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rows, err := store.AllRows()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("got %d rows, want one per team", len(rows))
+	}
+	// Each team's row holds its own capacity, not that of both teams
+	for i, want := range []struct {
+		team           string
+		points         float64
+		daysAvailable  float64
+		capacityPerDay float64
+	}{{"Team A", 21, 38, 4}, {"Team B", 31, 20, 2}} {
+		row := rows[i]
+		if row.Team != want.team || row.PointsCompleted != want.points {
+			t.Errorf("row %d = team %q with %g points, want team %q with %g", i, row.Team, row.PointsCompleted, want.team, want.points)
+		}
+		if row.DaysAvailable != want.daysAvailable || row.CapacityPerDay != want.capacityPerDay {
+			t.Errorf("%s: days available %g and capacity per day %g, want %g and %g", row.Team, row.DaysAvailable, row.CapacityPerDay, want.daysAvailable, want.capacityPerDay)
+		}
+	}
+}
+
+func TestUpdateForecastsWithoutCalculatedSprints(t *testing.T) {
+	store := newTestStore(t)
+	// No sprint has known points, so there is no velocity baseline
+	insertTestSprint(t, store, "Team A", 1, 10, -1, TimeframePast)
+	insertTestSprint(t, store, "Team A", 2, 10, -1, TimeframeCurrent)
+	insertTestSprint(t, store, "Team A", 3, 10, -1, TimeframeFuture)
+
+	if err := updateForecasts(store, "Team A", Args{}); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := store.AllRows()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range rows {
+		if row.AvgPointsCompleted != nil {
+			t.Errorf("sprint %d: average = %g, want NULL", row.SprintNumber, *row.AvgPointsCompleted)
+		}
+		if row.ForecastedCompleted != nil || row.ForecastLow != nil || row.ForecastHigh != nil {
+			t.Errorf("sprint %d: got a forecast, want none without a baseline", row.SprintNumber)
+		}
+	}
+}
+
+func TestUpdateForecastsCurrentSprintWithoutPoints(t *testing.T) {
+	store := newTestStore(t)
+	insertTestSprint(t, store, "Team A", 1, 10, 20, TimeframePast)
+	// No points entry yet, so its points are unknown
+	insertTestSprint(t, store, "Team A", 2, 10, -1, TimeframeCurrent)
+
+	if err := updateForecasts(store, "Team A", Args{}); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := store.AllRows()
+	if err != nil {
+		t.Fatal(err)
+	}
+	current := rows[1]
+	if current.ForecastedCompleted == nil || *current.ForecastedCompleted != 20 {
+		t.Errorf("forecast of the current sprint = %v, want 20", current.ForecastedCompleted)
+	}
+	if current.ForecastLow == nil || current.ForecastHigh == nil || *current.ForecastHigh <= 0 {
+		t.Errorf("forecast range of the current sprint = %v to %v, want a positive range", current.ForecastLow, current.ForecastHigh)
+	}
+}
+
+func TestUpdateForecastsForecastError(t *testing.T) {
+	store := newTestStore(t)
+	insertTestSprint(t, store, "Team A", 1, 10, 20, TimeframePast)
+	insertTestSprint(t, store, "Team A", 2, 10, 40, TimeframePast)
+	// The current sprint's points are known so far, but still growing
+	insertTestSprint(t, store, "Team A", 3, 10, 10, TimeframeCurrent)
+	insertTestSprint(t, store, "Team A", 4, 10, 0, TimeframeFuture)
+
+	if err := updateForecasts(store, "Team A", Args{}); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := store.AllRows()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Sprint 1 has no earlier sprint to be forecast from; sprint 2 is
+	// forecast from the ratio 2 of sprint 1 alone, not from its own ratio.
+	want := map[int]float64{2: 40 - 20}
+	for _, row := range rows {
+		wantError, ok := want[row.SprintNumber]
+		switch {
+		case !ok && row.ForecastError != nil:
+			t.Errorf("sprint %d (%s): forecast error = %g, want NULL", row.SprintNumber, row.Timeframe, *row.ForecastError)
+		case ok && row.ForecastError == nil:
+			t.Errorf("sprint %d: got no forecast error, want %g", row.SprintNumber, wantError)
+		case ok && *row.ForecastError != wantError:
+			t.Errorf("sprint %d: forecast error = %g, want %g", row.SprintNumber, *row.ForecastError, wantError)
+		}
+	}
+}
+
+func TestUpdateAveragesCriterion(t *testing.T) {
+	store := newTestStore(t)
+	insertTestSprint(t, store, "Team A", 1, 10, 20, TimeframePast)
+	insertTestSprint(t, store, "Team A", 2, 10, 40, TimeframePast)
+	// Zero points, unknown points and no capacity
+	insertTestSprint(t, store, "Team A", 3, 10, 0, TimeframePast)
+	insertTestSprint(t, store, "Team A", 4, 10, -1, TimeframePast)
+	insertTestSprint(t, store, "Team A", 5, 0, 30, TimeframePast)
+	// A sprint of another team never counts
+	insertTestSprint(t, store, "Team B", 1, 10, 90, TimeframePast)
+
+	tests := []struct {
+		name     string
+		baseline Baseline
+		want     float64
+	}{
+		{name: "zero and unknown points excluded", baseline: Baseline{}, want: 3},
+		{name: "zero points included", baseline: Baseline{IncludeZeroPoints: true}, want: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := store.UpdateAverages("Team A", tt.baseline); err != nil {
+				t.Fatal(err)
+			}
+			if got := averageOf(t, store, "Team A"); got == nil || *got != tt.want {
+				t.Errorf("average = %v, want %g", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpdateAveragesExcludesSkippedAndCurrentSprints(t *testing.T) {
+	store := newTestStore(t)
+	insertTestSprint(t, store, "Team A", 1, 10, 20, TimeframePast)
+	insertTestSprint(t, store, "Team A", 2, 10, 10, TimeframeCurrent)
+	if err := store.InsertRow(IterationCapacityRow{Team: "Team A", Name: "Sprint 3", SprintNumber: 3, DaysAvailable: 10, PointsCompleted: 90,
+		PointsCompletedForTotalDays: 9, RatioStatus: capacity.RatioCalculated, Timeframe: TimeframePast, SkipForecast: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		baseline Baseline
+		want     float64
+	}{
+		{name: "current sprint included", baseline: Baseline{}, want: 1.5},
+		{name: "current sprint excluded", baseline: Baseline{ExcludeCurrent: true}, want: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := store.UpdateAverages("Team A", tt.baseline); err != nil {
+				t.Fatal(err)
+			}
+			if got := averageOf(t, store, "Team A"); got == nil || *got != tt.want {
+				t.Errorf("average = %v, want %g", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInitFreshKeepsOtherTables(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.sqlite")
+	open := func(table string, fresh bool) CapacityStore {
+		t.Helper()
+		store, err := newStore(DriverSQLite, path, table)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Init(fresh); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { store.Close() })
+		return store
+	}
+	teams := []capacity.TeamData{{TeamId: "team-a", TeamCapacityPerDay: 2}}
+	insert := func(store CapacityStore, team string) {
+		t.Helper()
+		record := SprintRecord{Row: IterationCapacityRow{Team: team, Name: "Sprint 1", SprintNumber: 1, DaysAvailable: 10, Timeframe: TimeframePast, DaysOffSubtracted: true}, Teams: teams}
+		if err := store.InsertSprints([]SprintRecord{record}, "2026-10-16T00:00:00Z"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	teamA := open("team_a", true)
+	insert(teamA, "Team A")
+	// A fresh run into another table of the same database
+	teamB := open("team_b", true)
+	insert(teamB, "Team B")
+
+	rows, err := teamA.AllRows()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0].Team != "Team A" {
+		t.Errorf("team_a holds %d rows after a fresh run into team_b, want its own row", len(rows))
+	}
+
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for _, table := range []string{"team_a_team_capacity", "team_b_team_capacity"} {
+		var count int
+		if err := db.QueryRow(`SELECT COUNT(*) FROM ` + table).Scan(&count); err != nil {
+			t.Fatal(err)
+		}
+		if count != 1 {
+			t.Errorf("%s holds %d rows, want 1", table, count)
+		}
+	}
+}
+
+func TestRecomputePointsDaysInSprint(t *testing.T) {
+	store := newTestStore(t)
+	for _, row := range []IterationCapacityRow{
+		// Stored by a run in which sprint 1 was shortened to 8 days
+		{Team: "Team A", Name: "Sprint 1", SprintNumber: 1, DaysAvailable: 14, CapacityPerDay: 2, DaysOff: 2, Timeframe: TimeframePast, DaysOffSubtracted: true},
+		{Team: "Team A", Name: "Sprint 2", SprintNumber: 2, DaysAvailable: 18, CapacityPerDay: 2, DaysOff: 2, Timeframe: TimeframePast, DaysOffSubtracted: true},
+	} {
+		if err := store.InsertRow(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	points := []PointsCompleted{
+		{SprintNumber: 1, Completed: 21, Calculate: true, DaysInSprint: 8},
+		{SprintNumber: 2, Completed: 27, Calculate: true},
+	}
+	days := func(row IterationCapacityRow) (float64, error) {
+		return storedSprintDays(row, 10, false, nil)
+	}
+	if err := recomputePoints(store, "Team A", points, days); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := store.AllRows()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []struct{ daysAvailable, ratio float64 }{{14, 1.5}, {18, 1.5}} {
+		if rows[i].DaysAvailable != want.daysAvailable || rows[i].PointsCompletedForTotalDays != want.ratio {
+			t.Errorf("sprint %d: days available %g and ratio %g, want %g and %g",
+				rows[i].SprintNumber, rows[i].DaysAvailable, rows[i].PointsCompletedForTotalDays, want.daysAvailable, want.ratio)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"strings"
+)
+
+// Dimensions of the velocity chart in pixels.
+const (
+	svgWidth   = 720
+	svgHeight  = 360
+	svgMargin  = 50
+	svgPadding = 10
+)
+
+// svgColors are the line colors of the teams, used in turn.
+var svgColors = []string{"#4a90d9", "#e07b39", "#4caf50", "#9c27b0", "#795548"}
+
+// exportSVG writes a line chart of the completion ratio per sprint to path,
+// with the average of each team as a dashed line. Only the sprints of the
+// baseline are plotted, so future sprints without points do not show as
+// drops to zero.
+func exportSVG(rows []IterationCapacityRow, baseline Baseline, path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := writeSVG(file, rows, baseline); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// writeSVG writes the chart of exportSVG to w.
+func writeSVG(w io.Writer, rows []IterationCapacityRow, baseline Baseline) error {
+	var teams []string
+	points := make(map[string][]IterationCapacityRow)
+	average := make(map[string]*float64)
+	minSprint, maxSprint, plotted := 0, 0, 0
+	maxRatio := 0.0
+	for _, row := range rows {
+		if !baseline.includes(row) {
+			continue
+		}
+		if _, ok := points[row.Team]; !ok {
+			teams = append(teams, row.Team)
+		}
+		points[row.Team] = append(points[row.Team], row)
+		average[row.Team] = row.AvgPointsCompleted
+		if plotted == 0 || row.SprintNumber < minSprint {
+			minSprint = row.SprintNumber
+		}
+		if plotted == 0 || row.SprintNumber > maxSprint {
+			maxSprint = row.SprintNumber
+		}
+		plotted++
+		maxRatio = max(maxRatio, row.PointsCompletedForTotalDays)
+		if row.AvgPointsCompleted != nil {
+			maxRatio = max(maxRatio, *row.AvgPointsCompleted)
+		}
+	}
+	if maxRatio == 0 {
+		maxRatio = 1
+	}
+
+	plotWidth := float64(svgWidth - 2*svgMargin)
+	plotHeight := float64(svgHeight - 2*svgMargin)
+	x := func(sprint int) float64 {
+		if maxSprint == minSprint {
+			return svgMargin + plotWidth/2
+		}
+		return svgMargin + float64(sprint-minSprint)/float64(maxSprint-minSprint)*plotWidth
+	}
+	y := func(ratio float64) float64 {
+		return svgMargin + plotHeight - ratio/maxRatio*plotHeight
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %[1]d %[2]d" font-family="sans-serif" font-size="12">`+"\n", svgWidth, svgHeight)
+	fmt.Fprintf(&b, `<rect width="%d" height="%d" fill="white"/>`+"\n", svgWidth, svgHeight)
+	fmt.Fprintf(&b, `<text x="%d" y="%d" font-size="16">Points completed per day available</text>`+"\n", svgMargin, svgMargin-2*svgPadding)
+
+	// Axes with the sprint range and the highest ratio as labels
+	bottom, right := svgHeight-svgMargin, svgWidth-svgMargin
+	fmt.Fprintf(&b, `<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="#444"/>`+"\n", svgMargin, bottom, right, bottom)
+	fmt.Fprintf(&b, `<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="#444"/>`+"\n", svgMargin, svgMargin, svgMargin, bottom)
+	fmt.Fprintf(&b, `<text x="%d" y="%d" text-anchor="end">%.2f</text>`+"\n", svgMargin-svgPadding/2, svgMargin+4, maxRatio)
+	fmt.Fprintf(&b, `<text x="%d" y="%d" text-anchor="end">0</text>`+"\n", svgMargin-svgPadding/2, bottom+4)
+	if len(teams) > 0 {
+		fmt.Fprintf(&b, `<text x="%.1f" y="%d" text-anchor="middle">%d</text>`+"\n", x(minSprint), bottom+2*svgPadding, minSprint)
+		if maxSprint != minSprint {
+			fmt.Fprintf(&b, `<text x="%.1f" y="%d" text-anchor="middle">%d</text>`+"\n", x(maxSprint), bottom+2*svgPadding, maxSprint)
+		}
+	}
+	fmt.Fprintf(&b, `<text x="%d" y="%d" text-anchor="middle">Sprint</text>`+"\n", svgWidth/2, svgHeight-svgPadding)
+
+	for i, team := range teams {
+		color := svgColors[i%len(svgColors)]
+
+		var coords []string
+		for _, row := range points[team] {
+			coords = append(coords, fmt.Sprintf("%.1f,%.1f", x(row.SprintNumber), y(row.PointsCompletedForTotalDays)))
+		}
+		fmt.Fprintf(&b, `<polyline points="%s" fill="none" stroke="%s" stroke-width="2"/>`+"\n", strings.Join(coords, " "), color)
+		for _, row := range points[team] {
+			fmt.Fprintf(&b, `<circle cx="%.1f" cy="%.1f" r="3" fill="%s"/>`+"\n", x(row.SprintNumber), y(row.PointsCompletedForTotalDays), color)
+		}
+
+		if avg := average[team]; avg != nil {
+			avgY := y(*avg)
+			fmt.Fprintf(&b, `<line x1="%d" y1="%.1f" x2="%d" y2="%.1f" stroke="%s" stroke-dasharray="6,4"/>`+"\n", svgMargin, avgY, right, avgY, color)
+			fmt.Fprintf(&b, `<text x="%d" y="%d" fill="%s">%s (avg %.2f)</text>`+"\n", right-150, svgMargin+i*15, color, html.EscapeString(team), *avg)
+		} else {
+			fmt.Fprintf(&b, `<text x="%d" y="%d" fill="%s">%s</text>`+"\n", right-150, svgMargin+i*15, color, html.EscapeString(team))
+		}
+	}
+
+	b.WriteString("</svg>\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"slingshot.ninja/devops/iterationcapacity/capacity"
+)
+
+func TestWriteSVGPlotsBaselineSprints(t *testing.T) {
+	rows := []IterationCapacityRow{
+		{Team: "Team A", SprintNumber: 1, PointsCompleted: 20, PointsCompletedForTotalDays: 2, RatioStatus: capacity.RatioCalculated, Timeframe: TimeframePast},
+		{Team: "Team A", SprintNumber: 2, PointsCompleted: 30, PointsCompletedForTotalDays: 3, RatioStatus: capacity.RatioCalculated, Timeframe: TimeframePast},
+		// A future sprint listed with zero points and calculate: true
+		{Team: "Team A", SprintNumber: 3, PointsCompleted: 0, RatioStatus: capacity.RatioCalculated, Timeframe: TimeframeFuture},
+		{Team: "Team A", SprintNumber: 4, PointsCompleted: 25, PointsCompletedForTotalDays: 2.5, RatioStatus: capacity.RatioCalculated, Timeframe: TimeframePast, SkipForecast: true},
+		{Team: "Team A", SprintNumber: 5, PointsCompleted: -1, RatioStatus: capacity.RatioNotCalculated, Timeframe: TimeframeFuture},
+	}
+	tests := []struct {
+		name     string
+		baseline Baseline
+		want     int
+	}{
+		{name: "default baseline", baseline: Baseline{}, want: 2},
+		{name: "zero point sprints included", baseline: Baseline{IncludeZeroPoints: true}, want: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b strings.Builder
+			if err := writeSVG(&b, rows, tt.baseline); err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.Count(b.String(), "<circle "); got != tt.want {
+				t.Errorf("plotted %d sprints, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"log/slog"
+	"os"
+	"strings"
+
+	"slingshot.ninja/devops/iterationcapacity/capacity"
+)
+
+// readTeamListFile reads the teams to process from a text file with one team
+// per line. Everything after a # is a comment; blank lines and surrounding
+// whitespace are ignored, and a team listed twice is processed once.
+func readTeamListFile(filename string) ([]string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var teams []string
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		team, _, _ := strings.Cut(scanner.Text(), "#")
+		team = strings.TrimSpace(team)
+		if team == "" {
+			continue
+		}
+		if seen[strings.ToLower(team)] {
+			slog.Warn("Team is listed more than once; processing it once", "team", team)
+			continue
+		}
+		seen[strings.ToLower(team)] = true
+		teams = append(teams, team)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(teams) == 0 {
+		return nil, errors.New("no teams listed")
+	}
+	return teams, nil
+}
+
+// pointsForTeam returns the entries of the points data that apply to the
+// team: those tagged with the team, followed by those without a team, which
+// apply to every team. The lookups use the first matching entry, so an entry
+// for the team wins over an untagged one for the same sprint.
+func pointsForTeam(pointsData []PointsCompleted, team string) []PointsCompleted {
+	var tagged, untagged []PointsCompleted
+	for _, points := range pointsData {
+		switch {
+		case points.Team == "":
+			untagged = append(untagged, points)
+		case strings.EqualFold(points.Team, team):
+			tagged = append(tagged, points)
+		}
+	}
+	return append(tagged, untagged...)
+}
+
+// teamSprints are the sprints of one team that are ready to be fetched,
+// with the points data that applies to the team.
+type teamSprints struct {
+	Team string
+	// TeamID picks the team's entry out of the capacities of an
+	// iteration, which cover every team in it.
+	TeamID string
+	// TeamValues select the team's work items when the points are read
+	// from them.
+	TeamValues capacity.TeamFieldValues
+	Points     []PointsCompleted
+	Sprints    []sprintIteration
+}